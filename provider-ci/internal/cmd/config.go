@@ -0,0 +1,220 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pulumi/ci-mgmt/provider-ci/internal/pkg"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// configCmd groups commands which work with .ci-mgmt.yaml.
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Work with .ci-mgmt.yaml configuration",
+}
+
+// configSchemaCmd represents the config schema command
+var configSchemaCmd = &cobra.Command{
+	Use:   "schema",
+	Short: "Print a JSON Schema for .ci-mgmt.yaml",
+	Long: `Print a JSON Schema for .ci-mgmt.yaml, derived from the configuration this
+binary understands. Editors can use it to validate and complete provider
+configuration, e.g. with a yaml-language-server modeline:
+
+  # yaml-language-server: $schema=https://raw.githubusercontent.com/pulumi/ci-mgmt/master/provider-ci/ci-mgmt.schema.json`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		schema, err := pkg.ConfigSchema()
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		return enc.Encode(schema)
+	},
+}
+
+var configResolvedArgs struct {
+	ConfigPath     string
+	TemplateSource string
+	TemplateName   string
+	RepositoryName string
+	Output         string
+}
+
+// configResolvedCmd represents the config resolved command
+var configResolvedCmd = &cobra.Command{
+	Use:   "resolved",
+	Short: "Print the configuration templates are rendered with",
+	Long: `Print the configuration templates are rendered with: .ci-mgmt.yaml merged
+with the template defaults and the files and presets it extends, followed by
+the envOverride merge and the implicit defaults generate applies. Every field is
+shown; each value is annotated with what set it and fields without an
+annotation were never set.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if configResolvedArgs.Output != "yaml" && configResolvedArgs.Output != "json" {
+			return fmt.Errorf("unknown output format %q: must be yaml or json", configResolvedArgs.Output)
+		}
+		templates, err := pkg.LoadTemplateSource(configResolvedArgs.TemplateSource)
+		if err != nil {
+			return err
+		}
+		resolved, err := pkg.ResolveConfig(templates, configResolvedArgs.ConfigPath)
+		if err != nil {
+			return err
+		}
+
+		// Same priority as generate: CLI flag > config file
+		templateName := configResolvedArgs.TemplateName
+		if templateName == "" {
+			templateName = resolved.Config.Template
+		}
+		repositoryName := configResolvedArgs.RepositoryName
+		if repositoryName == "" {
+			repositoryName = pkg.RepositoryName(resolved.Config)
+		}
+		resolved.ApplyImplicitDefaults(templateName, repositoryName)
+
+		if configResolvedArgs.Output == "json" {
+			out, err := resolved.JSON()
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(out))
+			return nil
+		}
+		out, err := resolved.AnnotatedYAML()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("# Layers, in order: %s\n", strings.Join(resolved.Layers, ", "))
+		fmt.Print(string(out))
+		return nil
+	},
+}
+
+var configEditArgs struct {
+	ConfigPath     string
+	TemplateSource string
+	Resolved       bool
+	Append         bool
+	Remove         bool
+}
+
+// configGetCmd represents the config get command
+var configGetCmd = &cobra.Command{
+	Use:   "get <key>",
+	Short: "Print a value from .ci-mgmt.yaml",
+	Long: `Print the value at a dotted key such as esc.environment from .ci-mgmt.yaml.
+Scalars are printed as is and anything else as YAML. Exits non-zero if the file
+doesn't set the key, unless --resolved is given to print the value templates
+see instead.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+		var value *yaml.Node
+		var err error
+		if configEditArgs.Resolved {
+			templates, err := pkg.LoadTemplateSource(configEditArgs.TemplateSource)
+			if err != nil {
+				return err
+			}
+			value, err = pkg.GetResolvedConfigValue(templates, configEditArgs.ConfigPath, args[0])
+			if err != nil {
+				return err
+			}
+		} else if value, err = pkg.GetConfigValue(configEditArgs.ConfigPath, args[0]); err != nil {
+			return err
+		}
+		if value == nil {
+			return fmt.Errorf("%s is not set in %s", args[0], configEditArgs.ConfigPath)
+		}
+		if value.Kind == yaml.ScalarNode {
+			fmt.Println(value.Value)
+			return nil
+		}
+		enc := yaml.NewEncoder(os.Stdout)
+		enc.SetIndent(2)
+		if err := enc.Encode(value); err != nil {
+			return err
+		}
+		return enc.Close()
+	},
+}
+
+// configSetCmd represents the config set command
+var configSetCmd = &cobra.Command{
+	Use:   "set <key> <value>",
+	Short: "Set a value in .ci-mgmt.yaml, keeping its comments",
+	Long: `Set the value at a dotted key such as esc.environment in .ci-mgmt.yaml,
+creating any mappings leading to it. The value is parsed as YAML, e.g. true, 3,
+[nodejs, go] or {name: random, version: 4.16.0}, and must suit the field.
+Comments, ordering and indentation in the rest of the file are kept.
+
+With --append or --remove the key must be a list such as languages or plugins,
+and the value is an element or a list of elements. Elements of plugins may be
+removed by name. Appending to a list the file doesn't set adds a "key+" entry
+which extends the inherited list.`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+		key, value := args[0], args[1]
+		switch {
+		case configEditArgs.Append && configEditArgs.Remove:
+			return fmt.Errorf("--append and --remove can't be used together")
+		case configEditArgs.Append:
+			return pkg.AppendConfigValues(configEditArgs.ConfigPath, key, value)
+		case configEditArgs.Remove:
+			templates, err := pkg.LoadTemplateSource(configEditArgs.TemplateSource)
+			if err != nil {
+				return err
+			}
+			_, err = pkg.RemoveConfigValues(templates, configEditArgs.ConfigPath, key, value)
+			return err
+		}
+		return pkg.SetConfigValue(configEditArgs.ConfigPath, key, value)
+	},
+}
+
+// configUnsetCmd represents the config unset command
+var configUnsetCmd = &cobra.Command{
+	Use:   "unset <key>",
+	Short: "Remove a value from .ci-mgmt.yaml, keeping its comments",
+	Long: `Remove the value at a dotted key such as esc.environment from .ci-mgmt.yaml,
+along with anything appended to it with "key+", so the inherited value applies.
+Does nothing if the file doesn't set the key.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+		_, err := pkg.UnsetConfigValue(configEditArgs.ConfigPath, args[0])
+		return err
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configSchemaCmd)
+	configCmd.AddCommand(configResolvedCmd)
+	configCmd.AddCommand(configGetCmd)
+	configCmd.AddCommand(configSetCmd)
+	configCmd.AddCommand(configUnsetCmd)
+
+	configResolvedCmd.Flags().StringVarP(&configResolvedArgs.ConfigPath, "config", "c", ".ci-mgmt.yaml", "config file to resolve")
+	configResolvedCmd.Flags().StringVar(&configResolvedArgs.TemplateSource, "template-source", "", templateSourceUsage)
+	configResolvedCmd.Flags().StringVarP(&configResolvedArgs.TemplateName, "template", "t", "", "template name to resolve for (default \"{config.template}\")")
+	configResolvedCmd.Flags().StringVarP(&configResolvedArgs.RepositoryName, "name", "n", "", "repository name to resolve for (default \"{config.repository}\" or otherwise \"{config.organization}/pulumi-{config.provider}\")")
+	configResolvedCmd.Flags().StringVar(&configResolvedArgs.Output, "output", "yaml", "output format: yaml or json")
+
+	for _, c := range []*cobra.Command{configGetCmd, configSetCmd, configUnsetCmd} {
+		c.Flags().StringVarP(&configEditArgs.ConfigPath, "config", "c", ".ci-mgmt.yaml", "config file to use")
+	}
+	configGetCmd.Flags().BoolVar(&configEditArgs.Resolved, "resolved", false, "print the value templates see, including inherited and default values")
+	configGetCmd.Flags().StringVar(&configEditArgs.TemplateSource, "template-source", "", templateSourceUsage)
+	configSetCmd.Flags().BoolVar(&configEditArgs.Append, "append", false, "append the value to a list")
+	configSetCmd.Flags().BoolVar(&configEditArgs.Remove, "remove", false, "remove the value from a list")
+	configSetCmd.Flags().StringVar(&configEditArgs.TemplateSource, "template-source", "", templateSourceUsage)
+}
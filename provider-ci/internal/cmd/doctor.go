@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/pulumi/ci-mgmt/provider-ci/internal/pkg"
+	"github.com/spf13/cobra"
+)
+
+var doctorArgs struct {
+	ConfigPath     string
+	Dir            string
+	TemplateSource string
+}
+
+// doctorCmd represents the doctor command
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check .ci-mgmt.yaml against the repository it generates into.",
+	Long: `Check .ci-mgmt.yaml for problems as validate does, then cross-check it against
+the repository: major-version and modulePath against go.mod, languages against
+the directories in sdk, and that sdkModuleDir, test-folder, setup-script and
+providerVersion point at things which exist. Mismatches would otherwise only
+show up as confusing CI failures.
+
+The same checks run before generate renders a Pulumi provider, against its
+output directory and repository name.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		templates, err := pkg.LoadTemplateSource(doctorArgs.TemplateSource)
+		if err != nil {
+			return err
+		}
+		dir := doctorArgs.Dir
+		if dir == "" {
+			dir = filepath.Dir(doctorArgs.ConfigPath)
+		}
+		diags, err := pkg.DiagnoseConfig(templates, doctorArgs.ConfigPath, dir, "", "")
+		if err != nil {
+			return err
+		}
+		if err := reportDiagnostics(os.Stdout, doctorArgs.ConfigPath, diags); err != nil {
+			cmd.SilenceUsage = true
+			return err
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+
+	doctorCmd.Flags().StringVarP(&doctorArgs.ConfigPath, "config", "c", ".ci-mgmt.yaml", "config file to check")
+	doctorCmd.Flags().StringVarP(&doctorArgs.Dir, "dir", "d", "", "repository to check the config against (default: the directory containing the config)")
+	doctorCmd.Flags().StringVar(&doctorArgs.TemplateSource, "template-source", "", templateSourceUsage)
+}
@@ -1,9 +1,11 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"strings"
+	"text/tabwriter"
 
 	"github.com/pulumi/ci-mgmt/provider-ci/internal/pkg"
 	"github.com/spf13/cobra"
@@ -15,8 +17,14 @@ type generateArguments struct {
 	TemplateName   string
 	ConfigPath     string
 	SkipMigrations bool
+	Check          bool
+	Force          bool
+	TemplateSource string
+	Output         string
 }
 
+const templateSourceUsage = "directory or .tar/.tar.gz/.tgz archive laid out like the embedded templates to use instead of them; missing template directories fall back to the embedded ones"
+
 var generateArgs generateArguments
 
 // generateCmd represents the generate command
@@ -24,11 +32,26 @@ var generateCmd = &cobra.Command{
 	Use:   "generate",
 	Short: "Generate repository files.",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		config, err := pkg.LoadLocalConfig(generateArgs.ConfigPath)
+		if generateArgs.Output != "table" && generateArgs.Output != "json" {
+			return fmt.Errorf("unknown output format %q: must be table or json", generateArgs.Output)
+		}
+
+		templates, err := pkg.LoadTemplateSource(generateArgs.TemplateSource)
 		if err != nil {
 			return err
 		}
 
+		// Deprecated fields are reported with the diagnostics below.
+		config, err := pkg.LoadLocalConfigFrom(templates, generateArgs.ConfigPath, nil)
+		if err != nil {
+			// Report where the config is invalid if validation can tell.
+			if diags, diagErr := pkg.ValidateConfig(templates, generateArgs.ConfigPath, generateArgs.OutDir); diagErr == nil && pkg.HasErrors(diags) {
+				cmd.SilenceUsage = true
+				return reportDiagnostics(os.Stderr, generateArgs.ConfigPath, diags)
+			}
+			return err
+		}
+
 		// Template name priority: CLI flag > config file
 		if generateArgs.TemplateName == "" {
 			if config.Template != "" {
@@ -38,15 +61,7 @@ var generateCmd = &cobra.Command{
 
 		// Name priority: CLI flag > config file ("repository", then "name" field)
 		if generateArgs.RepositoryName == "" {
-			if config.Repository != "" {
-				generateArgs.RepositoryName = config.Repository
-			} else {
-				providerName := config.Provider
-				organizationName := config.Organization
-				if providerName != "" && organizationName != "" {
-					generateArgs.RepositoryName = fmt.Sprintf("%s/pulumi-%s", organizationName, providerName)
-				}
-			}
+			generateArgs.RepositoryName = pkg.RepositoryName(config)
 		}
 
 		if generateArgs.RepositoryName == "" {
@@ -60,20 +75,99 @@ var generateCmd = &cobra.Command{
 		}
 		if parts[0] != "pulumi" {
 			fmt.Fprintln(os.Stderr, "Skipping workflow regeneration because this appears to be a third-party provider. Use github.com/pulumi-labs/ci-mgmt instead.")
-			return nil
+			return printGenerateResult(pkg.GenerateResult{}, generateArgs.Output)
 		}
 
-		err = pkg.GeneratePackage(pkg.GenerateOpts{
+		diags, err := pkg.DiagnoseConfig(templates, generateArgs.ConfigPath, generateArgs.OutDir, generateArgs.TemplateName, generateArgs.RepositoryName)
+		if err != nil {
+			return err
+		}
+		if err := reportDiagnostics(os.Stderr, generateArgs.ConfigPath, diags); err != nil {
+			cmd.SilenceUsage = true
+			return err
+		}
+
+		opts := pkg.GenerateOpts{
 			RepositoryName: generateArgs.RepositoryName,
 			OutDir:         generateArgs.OutDir,
+			Templates:      templates,
 			TemplateName:   generateArgs.TemplateName,
 			Config:         config,
 			SkipMigrations: generateArgs.SkipMigrations,
-		})
-		return err
+			Force:          generateArgs.Force,
+		}
+		if generateArgs.Output == "json" {
+			// Keep stdout for the result.
+			opts.Stdout = os.Stderr
+		}
+
+		if generateArgs.Check {
+			changes, err := pkg.CheckPackage(opts)
+			if err != nil {
+				return err
+			}
+			if len(changes) > 0 || generateArgs.Output == "json" {
+				if err := printGenerateResult(pkg.GenerateResult{Files: changes}, generateArgs.Output); err != nil {
+					return err
+				}
+			}
+			if len(changes) > 0 {
+				cmd.SilenceUsage = true
+				return fmt.Errorf("%d generated file(s) are out of date; run provider-ci generate to update them", len(changes))
+			}
+			return nil
+		}
+
+		result, err := pkg.GeneratePackage(opts)
+		if err != nil {
+			return err
+		}
+		return printGenerateResult(result, generateArgs.Output)
 	},
 }
 
+// printGenerateResult prints result to stdout as JSON, or as a table of the
+// files which changed or were skipped followed by a count of each action.
+func printGenerateResult(result pkg.GenerateResult, format string) error {
+	if format == "json" {
+		if result.Files == nil {
+			result.Files = []pkg.FileChange{}
+		}
+		out, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(out))
+		return nil
+	}
+
+	counts := map[pkg.FileAction]int{}
+	var listed []pkg.FileChange
+	for _, f := range result.Files {
+		counts[f.Action]++
+		if f.Action != pkg.FileUnchanged {
+			listed = append(listed, f)
+		}
+	}
+	if len(listed) > 0 {
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ACTION\tPATH\tSOURCE")
+		for _, f := range listed {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", f.Action, f.Path, f.Source)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("%d created, %d updated, %d deleted, %d unchanged",
+		counts[pkg.FileCreated], counts[pkg.FileUpdated], counts[pkg.FileDeleted], counts[pkg.FileUnchanged])
+	if n := counts[pkg.FileSkipped]; n > 0 {
+		fmt.Printf(", %d skipped (modified by hand)", n)
+	}
+	fmt.Println()
+	return nil
+}
+
 func init() {
 	rootCmd.AddCommand(generateCmd)
 
@@ -82,4 +176,8 @@ func init() {
 	generateCmd.Flags().StringVarP(&generateArgs.TemplateName, "template", "t", "", "template name to generate (default \"{config.template}\" or otherwise \"bridged-provider\")")
 	generateCmd.Flags().StringVarP(&generateArgs.ConfigPath, "config", "c", ".ci-mgmt.yaml", "local config file to use")
 	generateCmd.Flags().BoolVar(&generateArgs.SkipMigrations, "skip-migrations", false, "skip running migrations")
+	generateCmd.Flags().BoolVar(&generateArgs.Check, "check", false, "report files which differ from the templates without writing anything (migrations are not run) and exit non-zero if any do")
+	generateCmd.Flags().StringVar(&generateArgs.TemplateSource, "template-source", "", templateSourceUsage)
+	generateCmd.Flags().StringVar(&generateArgs.Output, "output", "table", "format of the summary of generated files: table or json")
+	generateCmd.Flags().BoolVar(&generateArgs.Force, "force", false, "overwrite or delete generated files even if they were modified by hand since the last generation")
 }
@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"runtime"
+	"strings"
+
+	"github.com/pulumi/ci-mgmt/provider-ci/internal/pkg"
+	"github.com/spf13/cobra"
+)
+
+type generateFleetArguments struct {
+	Dir            string
+	ProvidersPath  string
+	Parallelism    int
+	TemplateSource string
+	Force          bool
+}
+
+var generateFleetArgs generateFleetArguments
+
+// generateFleetCmd represents the generate-fleet command
+var generateFleetCmd = &cobra.Command{
+	Use:   "generate-fleet",
+	Short: "Generate files for many provider repositories at once.",
+	Long: `Generate files for every provider in the provider list from the .ci-mgmt.yaml
+in its checkout at {dir}/pulumi-{provider}. Output from each provider is
+printed together, followed by a report of which repositories changed, failed
+or were skipped. Migrations are not run.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		providers, err := pkg.ReadProviderList(generateFleetArgs.ProvidersPath)
+		if err != nil {
+			return err
+		}
+		templates, err := pkg.LoadTemplateSource(generateFleetArgs.TemplateSource)
+		if err != nil {
+			return err
+		}
+
+		results := pkg.GenerateFleet(pkg.FleetOpts{
+			Dir:         generateFleetArgs.Dir,
+			Providers:   providers,
+			Parallelism: generateFleetArgs.Parallelism,
+			Templates:   templates,
+			Force:       generateFleetArgs.Force,
+		})
+
+		byStatus := map[pkg.FleetStatus][]pkg.FleetResult{}
+		for _, result := range results {
+			printPrefixed(result.Provider, result.Log)
+			for _, change := range result.Changes {
+				fmt.Printf("[%s] %s: %s\n", result.Provider, change.Action, change.Path)
+			}
+			byStatus[result.Status] = append(byStatus[result.Status], result)
+		}
+
+		fmt.Println()
+		for _, status := range []pkg.FleetStatus{pkg.FleetChanged, pkg.FleetUnchanged, pkg.FleetSkipped, pkg.FleetFailed} {
+			fmt.Printf("%s: %d\n", status, len(byStatus[status]))
+			for _, result := range byStatus[status] {
+				switch {
+				case result.Err != nil:
+					fmt.Printf("  %s: %v\n", result.Provider, result.Err)
+				case result.Reason != "":
+					fmt.Printf("  %s: %s\n", result.Provider, result.Reason)
+				case status == pkg.FleetChanged:
+					fmt.Printf("  %s: %d file(s)\n", result.Provider, len(result.Changes))
+				}
+			}
+		}
+
+		if failed := len(byStatus[pkg.FleetFailed]); failed > 0 {
+			cmd.SilenceUsage = true
+			return fmt.Errorf("%d of %d provider(s) failed to generate", failed, len(results))
+		}
+		return nil
+	},
+}
+
+// printPrefixed prints each line of log prefixed with the provider name.
+func printPrefixed(provider string, log []byte) {
+	scanner := bufio.NewScanner(bytes.NewReader(log))
+	for scanner.Scan() {
+		fmt.Printf("[%s] %s\n", provider, strings.TrimRight(scanner.Text(), "\r"))
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(generateFleetCmd)
+
+	generateFleetCmd.Flags().StringVarP(&generateFleetArgs.Dir, "dir", "d", ".", "directory containing a checkout of each provider repository, named pulumi-{provider}")
+	generateFleetCmd.Flags().StringVarP(&generateFleetArgs.ProvidersPath, "providers", "p", "providers.json", "JSON list of provider names to generate")
+	generateFleetCmd.Flags().IntVarP(&generateFleetArgs.Parallelism, "parallel", "j", runtime.NumCPU(), "number of providers to generate at once")
+	generateFleetCmd.Flags().StringVar(&generateFleetArgs.TemplateSource, "template-source", "", templateSourceUsage)
+	generateFleetCmd.Flags().BoolVar(&generateFleetArgs.Force, "force", false, "overwrite or delete generated files even if they were modified by hand since the last generation")
+}
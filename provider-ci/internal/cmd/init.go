@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/pulumi/ci-mgmt/provider-ci/internal/pkg"
+	"github.com/spf13/cobra"
+)
+
+var initArgs struct {
+	Dir   string
+	Force bool
+	Print bool
+}
+
+// initCmd represents the init command
+var initCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Write a starting .ci-mgmt.yaml inferred from a provider repository.",
+	Long: `Write a starting .ci-mgmt.yaml for the provider repository in --dir. The
+provider name, organization and major version are read from the module path in
+provider/go.mod, the template from whether provider/resources.go uses the
+Terraform bridge or, for other providers, whether the schema is checked in at
+provider/cmd/pulumi-resource-<provider>/schema.json without an upstream
+submodule as native providers do, languages from the subdirectories of sdk and docker from
+whether testing/docker-compose.yml exists. Everything else is left to its
+default.
+
+Each value is commented with whether it was inferred or is a default. The
+config is validated before it is written, and isn't written if it has errors
+unless --force is passed.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		result, err := pkg.InitConfig(initArgs.Dir)
+		if err != nil {
+			return err
+		}
+		if initArgs.Print {
+			_, err := os.Stdout.Write(result.Content)
+			return err
+		}
+
+		path := filepath.Join(initArgs.Dir, ".ci-mgmt.yaml")
+		if _, err := os.Stat(path); err == nil && !initArgs.Force {
+			cmd.SilenceUsage = true
+			return fmt.Errorf("%s already exists; use --force to overwrite it", path)
+		} else if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+
+		templates, err := pkg.LoadTemplateSource("")
+		if err != nil {
+			return err
+		}
+		diags, err := pkg.ValidateConfigData(templates, path, result.Content, initArgs.Dir)
+		if err != nil {
+			return err
+		}
+		invalid := reportDiagnostics(os.Stderr, path, diags)
+		if invalid != nil && !initArgs.Force {
+			cmd.SilenceUsage = true
+			return fmt.Errorf("%w; not writing it (use --force to write it anyway)", invalid)
+		}
+
+		if err := os.WriteFile(path, result.Content, 0o644); err != nil {
+			return fmt.Errorf("error writing %s: %w", path, err)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "KEY\tVALUE\tSOURCE")
+		for _, v := range result.Values {
+			source := string(v.Source)
+			if v.Reason != "" {
+				source += ": " + v.Reason
+			}
+			fmt.Fprintf(w, "%s\t%v\t%s\n", v.Key, v.Value, source)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		fmt.Printf("Wrote %s\n", path)
+		if invalid != nil {
+			cmd.SilenceUsage = true
+			return invalid
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(initCmd)
+
+	initCmd.Flags().StringVarP(&initArgs.Dir, "dir", "d", ".", "provider repository to inspect and write .ci-mgmt.yaml to")
+	initCmd.Flags().BoolVar(&initArgs.Force, "force", false, "overwrite an existing .ci-mgmt.yaml, and write the config even if it has errors")
+	initCmd.Flags().BoolVar(&initArgs.Print, "print", false, "print the config instead of writing it")
+}
@@ -8,12 +8,20 @@ import (
 	"gopkg.in/yaml.v3"
 )
 
+var listTemplatesArgs struct {
+	TemplateSource string
+}
+
 // listTemplatesCmd represents the listTemplates command
 var listTemplatesCmd = &cobra.Command{
 	Use:   "list-templates",
 	Short: "List available templates",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		templates, err := pkg.ListTemplates()
+		source, err := pkg.LoadTemplateSource(listTemplatesArgs.TemplateSource)
+		if err != nil {
+			return err
+		}
+		templates, err := pkg.ListTemplates(source)
 		if err != nil {
 			return err
 		}
@@ -28,4 +36,6 @@ var listTemplatesCmd = &cobra.Command{
 
 func init() {
 	rootCmd.AddCommand(listTemplatesCmd)
+
+	listTemplatesCmd.Flags().StringVar(&listTemplatesArgs.TemplateSource, "template-source", "", templateSourceUsage)
 }
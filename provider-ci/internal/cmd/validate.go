@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/pulumi/ci-mgmt/provider-ci/internal/pkg"
+	"github.com/spf13/cobra"
+)
+
+var validateArgs struct {
+	ConfigPath     string
+	TemplateSource string
+}
+
+// validateCmd represents the validate command
+var validateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Check .ci-mgmt.yaml for problems.",
+	Long: `Check .ci-mgmt.yaml for every problem which would stop generation or produce
+broken workflows, such as unknown fields, unknown templates or languages and
+paths which don't exist in the repository. Each problem is reported with its
+position in the file and, where possible, how to fix it. Paths are resolved
+relative to the directory containing the config file.
+
+The same checks run before generate renders a Pulumi provider.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		templates, err := pkg.LoadTemplateSource(validateArgs.TemplateSource)
+		if err != nil {
+			return err
+		}
+		diags, err := pkg.ValidateConfig(templates, validateArgs.ConfigPath, filepath.Dir(validateArgs.ConfigPath))
+		if err != nil {
+			return err
+		}
+		if err := reportDiagnostics(os.Stdout, validateArgs.ConfigPath, diags); err != nil {
+			cmd.SilenceUsage = true
+			return err
+		}
+		return nil
+	},
+}
+
+// reportDiagnostics prints diags followed by a count of each severity. It
+// returns an error if any diagnostic is an error.
+func reportDiagnostics(w io.Writer, path string, diags []pkg.Diagnostic) error {
+	counts := map[pkg.Severity]int{}
+	for _, d := range diags {
+		fmt.Fprintln(w, d)
+		counts[d.Severity]++
+	}
+	if len(diags) > 0 {
+		fmt.Fprintf(w, "%d error(s), %d warning(s)\n", counts[pkg.SeverityError], counts[pkg.SeverityWarning])
+	}
+	if counts[pkg.SeverityError] > 0 {
+		return fmt.Errorf("%s has %d error(s)", path, counts[pkg.SeverityError])
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(validateCmd)
+
+	validateCmd.Flags().StringVarP(&validateArgs.ConfigPath, "config", "c", ".ci-mgmt.yaml", "config file to validate")
+	validateCmd.Flags().StringVar(&validateArgs.TemplateSource, "template-source", "", templateSourceUsage)
+}
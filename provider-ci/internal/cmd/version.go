@@ -0,0 +1,29 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/pulumi/ci-mgmt/provider-ci/internal/pkg"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// versionCmd represents the version command
+var versionCmd = &cobra.Command{
+	Use:   "version",
+	Short: "Print build information",
+	Long: `Print the ci-mgmt version recorded in .ci-mgmt.manifest.json, along with
+the revision and Go version this binary was built from.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		out, err := yaml.Marshal(pkg.ReadBuildInfo())
+		if err != nil {
+			return err
+		}
+		fmt.Print(string(out))
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(versionCmd)
+}
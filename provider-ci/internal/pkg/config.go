@@ -3,9 +3,12 @@ package pkg
 import (
 	"bytes"
 	_ "embed" // For embedding action versions.
+	"encoding/json"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
-	"path/filepath"
+	"strings"
 	"time"
 
 	"gopkg.in/yaml.v3"
@@ -19,6 +22,13 @@ type Config struct {
 	// Provider is required and is the name of the provider without the "pulumi-" prefix.
 	Provider string `yaml:"provider"`
 
+	// Extends lists config which this file builds on, applied in order before
+	// it: .yaml files relative to this one, or the names of presets bundled
+	// with ci-mgmt in provider-ci/internal/pkg/presets. Mappings are merged
+	// and lists are replaced, unless the key has a "+" suffix such as
+	// "plugins+", which appends to the inherited list.
+	Extends []string `yaml:"extends"`
+
 	// Repository is the optional repository of the provider.
 	Repository string `yaml:"repository"`
 
@@ -109,16 +119,40 @@ type Config struct {
 
 	// Env contains an assortment of properties for different purposes.
 	// Additional entries are added by individual providers for different
-	// reasons. All jobs currently get the same env for all steps but values
-	// might only be used for very specific purposes.
-	Env map[string]string `yaml:"env"`
+	// reasons. Each entry is a plaintext value, a GitHub secret or a secret
+	// from the ESC environment, given to the jobs its scope names (see
+	// EnvVar).
+	Env map[string]EnvVar `yaml:"env"`
 
 	// Overrides the default env rather than appending to it.
-	EnvOverride map[string]string `yaml:"envOverride"`
-
-	// Actions can contain preBuild and preTest additional steps to be spliced
-	// into workflows. The use of these hooks vary - quite a few just build
-	// upstream and run provider tests. Usage:
+	EnvOverride map[string]EnvVar `yaml:"envOverride"`
+
+	// JobEnv contains env entries for one kind of job, such as secrets which
+	// only the tests need, keyed by prerequisites, buildSdk, test, publish or
+	// upgradeProvider. Entries in env with a scope go to the same jobs. Test
+	// entries are given to test steps and the rest to the jobs themselves.
+	JobEnv jobEnv `yaml:"jobEnv"`
+
+	// PathFilters skips jobs on pull requests which don't change the files
+	// they depend on, keyed by buildProvider, buildSdk or test. A job runs
+	// when a changed file matches one of its include globs and none of its
+	// exclude globs, and whenever a job which depends on it runs: test
+	// depends on buildSdk, which depends on buildProvider. Filtering a kind
+	// therefore has no effect unless the kinds after it are filtered too.
+	// The sentinel check still passes when jobs are skipped.
+	PathFilters pathFilters `yaml:"pathFilters"`
+
+	// Concurrency sets the concurrency group of the generated workflows, keyed
+	// by pullRequest, main, release or upgrade, and whether a new run in the
+	// group cancels the one in progress. Groups may use GitHub expressions.
+	// Without an entry, only the pull request workflow of templates other than
+	// native has a group, cancelling runs for superseded commits.
+	Concurrency concurrencies `yaml:"concurrency"`
+
+	// Actions can contain additional steps to be spliced into workflows at
+	// preBuild, postBuild, preSdkBuild, preTest, postTest, prePublish,
+	// postPublish and preRelease. The use of these hooks vary - quite a few
+	// just build upstream and run provider tests. Usage:
 	// https://github.com/search?q=org%3Apulumi+path%3A.ci-mgmt.yaml+%22actions%3A%22&type=code
 	Actions actions `yaml:"actions"`
 
@@ -132,15 +166,24 @@ type Config struct {
 	// https://github.com/search?q=org%3Apulumi+path%3A.ci-mgmt.yaml+%22testPulumiExamples%3A%22&type=code
 	TestPulumiExamples bool `yaml:"testPulumiExamples"`
 
-	// Runner defines the runs-on property for various stages of the build
-	// These are not overridden by any providers:
-	// https://github.com/search?q=org%3Apulumi+path%3A.ci-mgmt.yaml+%22runner%3A%22&type=code
+	// Runner defines the runs-on property for various stages of the build.
+	// Each is a runner label, a list of labels or a runner group (see
+	// runner). Stages which aren't set use the default runner.
 	Runner struct {
-		Default         string `yaml:"default"`
-		Prerequisites   string `yaml:"prerequisites"`
-		BuildSDK        string `yaml:"buildSdk"`
-		Publish         string `yaml:"publish"`
-		UpgradeProvider string `yaml:"upgradeProvider"`
+		Default         *runner `yaml:"default"`
+		Prerequisites   *runner `yaml:"prerequisites"`
+		BuildSDK        *runner `yaml:"buildSdk"`
+		Publish         *runner `yaml:"publish"`
+		UpgradeProvider *runner `yaml:"upgradeProvider"`
+		// Test defaults to the buildSdk runner.
+		Test        *runner `yaml:"test"`
+		Lint        *runner `yaml:"lint"`
+		Maintenance *runner `yaml:"maintenance"`
+
+		// TestOS runs the tests on each of the given operating systems with a
+		// matrix dimension, on GitHub's latest hosted runner for each. It
+		// takes precedence over test.
+		TestOS []string `yaml:"testOs"`
 	} `yaml:"runner"`
 
 	// actionVersions should be used wherever we use external actions to make
@@ -224,7 +267,7 @@ type Config struct {
 	MaintenanceBranch string `yaml:"maintenanceBranch"`
 
 	// MaintenanceReleaseDay is the day of the month the security patch ticket
-	// opens, 1-28. Defaults to 1. Set it per provider so releases do not all land
+	// opens, 1-28. Defaults to 1 when unset or 0. Set it per provider so releases do not all land
 	// on the same day; the day the current major shipped is a reasonable choice.
 	MaintenanceReleaseDay int `yaml:"maintenanceReleaseDay"`
 
@@ -236,9 +279,9 @@ type Config struct {
 	// CleanGithubWorkflows deletes existing files within the .github/workflows
 	// directory, except where the file begins with the name of the provider
 	// (e.g. `aws-*`) which are considered provider-specific workflows.
-	// Defaults to true but this will likely change to false in the future once
-	// we've made the process of cleaning up removed and renamed workflows more
-	// reliable.
+	// Defaults to true. Once .ci-mgmt.manifest.json exists only the workflows
+	// it lists are deleted, and those edited by hand since they were generated
+	// are kept unless forced.
 	CleanGithubWorkflows bool `yaml:"clean-github-workflows"`
 
 	// ProviderVersion controls the path of the version LD flag. Only set for 3
@@ -263,9 +306,10 @@ type Config struct {
 	// EnableChangelog controls whether the changelog is generated. (only used by aws-native)
 	EnableChangelog string `yaml:"enableChangelog"`
 
-	// Deprecated configs
+	// Deprecated configs, see the deprecations package.
 
-	// Parallel has no effect but is set by some providers.
+	// Parallel sets goreleaser's parallelism for native providers. It has no
+	// effect for other templates but is set by some providers.
 	// https://github.com/search?q=org%3Apulumi+path%3A.ci-mgmt.yaml+%22parallel%3A%22&type=code
 	Parallel int `yaml:"parallel"`
 
@@ -280,11 +324,16 @@ type Config struct {
 	// https://github.com/search?q=org%3Apulumi+path%3A.ci-mgmt.yaml+%22team%3A%22&type=code
 	Team string `yaml:"team"`
 
-	// Timeout has no effect but is set by some providers. It can be specified
-	// as an int (minutes) or a string duration.
-	// https://github.com/search?q=org%3Apulumi+path%3A.ci-mgmt.yaml+%22timeout%3A%22&type=code
+	// Timeout is the timeout-minutes of every generated job unless
+	// jobTimeouts overrides it. It can be specified as an int (minutes) or a
+	// string duration such as "2h30m", and can't exceed GitHub's limit of 6
+	// hours. Jobs use GitHub's limit if it is unset.
 	Timeout intOrDuration `yaml:"timeout"`
 
+	// JobTimeouts overrides timeout for one kind of job, keyed by
+	// prerequisites, buildSdk, test or publish.
+	JobTimeouts jobTimeouts `yaml:"jobTimeouts"`
+
 	// MakeTemplate has no effect but is set by 78 providers.
 	// https://github.com/search?q=org%3Apulumi+path%3A.ci-mgmt.yaml+%22makeTemplate%3A%22&type=code
 	MakeTemplate string `yaml:"makeTemplate"`
@@ -394,37 +443,31 @@ type Config struct {
 }
 
 // LoadLocalConfig loads the provider configuration at the given path with
-// defaults applied.
+// the embedded defaults applied. Deprecated fields are reported on stderr.
 func LoadLocalConfig(path string) (Config, error) {
-	config, err := loadDefaultConfig()
-	if err != nil {
-		return Config{}, err
-	}
-
-	localConfigBytes, err := os.ReadFile(path)
-	if err != nil {
-		return Config{}, fmt.Errorf("error reading config file %s: %w", path, err)
-	}
+	return LoadLocalConfigFrom(embeddedTemplates, path, os.Stderr)
+}
 
-	dec := yaml.NewDecoder(bytes.NewReader(localConfigBytes))
-	dec.KnownFields(true)
-	err = dec.Decode(&config)
+// LoadLocalConfigFrom loads the provider configuration at the given path with
+// the defaults from the given template set applied (see LoadTemplateSource),
+// along with any files and presets it extends. Warnings about deprecated
+// fields are written to warnings, if not nil.
+func LoadLocalConfigFrom(templates fs.FS, path string, warnings io.Writer) (Config, error) {
+	resolved, err := ResolveConfig(templates, path)
 	if err != nil {
 		return Config{}, err
 	}
-
-	// Merge envOverride into config.Env (overriding specific keys rather than replacing all)
-	if config.EnvOverride != nil {
-		for k, v := range config.EnvOverride {
-			config.Env[k] = v
+	if warnings != nil {
+		for _, layer := range resolved.layers {
+			if !layer.isFile() {
+				continue
+			}
+			for _, d := range deprecationDiagnostics(layer.name, layer.root, resolved.Config.Template) {
+				fmt.Fprintln(warnings, d)
+			}
 		}
 	}
-
-	if config.ModulePath == "" {
-		config.ModulePath = "provider"
-	}
-
-	return config, nil
+	return resolved.Config, nil
 }
 
 type GitHubApp struct {
@@ -443,9 +486,167 @@ type plugin struct {
 	Kind    string `yaml:"kind"`
 }
 
+// actions holds the custom steps spliced into the generated workflows at each
+// hook point, in both the base and native workflows.
 type actions struct {
-	PreTest  any `yaml:"preTest"`
-	PreBuild any `yaml:"preBuild"`
+	// PreBuild runs in the prerequisites job before the provider is built.
+	PreBuild []Step `yaml:"preBuild"`
+	// PostBuild runs in the prerequisites job after the provider is built.
+	PostBuild []Step `yaml:"postBuild"`
+	// PreSDKBuild runs before each SDK is generated and built.
+	PreSDKBuild []Step `yaml:"preSdkBuild"`
+	// PreTest runs before the tests, and before verifying a release.
+	PreTest []Step `yaml:"preTest"`
+	// PostTest runs after the tests.
+	PostTest []Step `yaml:"postTest"`
+	// PrePublish runs before the SDKs are published to package registries.
+	PrePublish []Step `yaml:"prePublish"`
+	// PostPublish runs after the SDKs are published.
+	PostPublish []Step `yaml:"postPublish"`
+	// PreRelease runs before the GitHub release is created.
+	PreRelease []Step `yaml:"preRelease"`
+}
+
+// pathFilters holds the path filter of each kind of job in the pull request
+// workflow. Jobs without one always run.
+type pathFilters struct {
+	BuildProvider *pathFilter `yaml:"buildProvider"`
+	BuildSDK      *pathFilter `yaml:"buildSdk"`
+	Test          *pathFilter `yaml:"test"`
+}
+
+// pathFilter selects the changed files a job depends on with globs, as
+// matched by dorny/paths-filter.
+type pathFilter struct {
+	// Include defaults to every file.
+	Include []string `yaml:"include"`
+	Exclude []string `yaml:"exclude"`
+}
+
+// concurrencies holds the concurrency of each kind of workflow. Release covers
+// the release and prerelease workflows, and upgrade the upgrade-provider and
+// upgrade-bridge workflows.
+type concurrencies struct {
+	PullRequest *concurrency `yaml:"pullRequest"`
+	Main        *concurrency `yaml:"main"`
+	Release     *concurrency `yaml:"release"`
+	Upgrade     *concurrency `yaml:"upgrade"`
+}
+
+// concurrency is rendered as a workflow's concurrency.
+type concurrency struct {
+	// Group defaults to ${{ github.workflow }}-${{ github.ref }}, with
+	// -${{ github.sha }} appended for main and release.
+	Group            string `yaml:"group"`
+	CancelInProgress bool   `yaml:"cancelInProgress"`
+}
+
+// defaultConcurrencyGroup keeps runs of different workflows, branches and tags
+// apart.
+const defaultConcurrencyGroup = "${{ github.workflow }}-${{ github.ref }}"
+
+// defaultPublishConcurrencyGroup is the default group of main and release
+// workflows. GitHub keeps only the newest pending run in a group, so each
+// commit gets its own group to keep queued publishes.
+const defaultPublishConcurrencyGroup = defaultConcurrencyGroup + "-${{ github.sha }}"
+
+// concurrencyKinds are the kinds of workflow in concurrency.
+var concurrencyKinds = []string{"pullRequest", "main", "release", "upgrade"}
+
+// forKind returns the concurrency of workflows of the given kind, or nil if
+// they have none.
+func (c concurrencies) forKind(kind string) (*concurrency, error) {
+	var k *concurrency
+	switch kind {
+	case "pullRequest":
+		k = c.PullRequest
+	case "main":
+		k = c.Main
+	case "release":
+		k = c.Release
+	case "upgrade":
+		k = c.Upgrade
+	default:
+		return nil, fmt.Errorf("unknown kind of workflow %q", kind)
+	}
+	if k == nil {
+		return nil, nil
+	}
+	group := k.Group
+	switch {
+	case group != "":
+	case kind == "main" || kind == "release":
+		group = defaultPublishConcurrencyGroup
+	default:
+		group = defaultConcurrencyGroup
+	}
+	return &concurrency{Group: group, CancelInProgress: k.CancelInProgress}, nil
+}
+
+// Enabled reports whether any kind of job is filtered.
+func (f pathFilters) Enabled() bool {
+	return f.BuildProvider != nil || f.BuildSDK != nil || f.Test != nil
+}
+
+// kinds returns the kinds of job in the order they depend on each other,
+// along with their filters.
+func (f pathFilters) kinds() ([]string, []*pathFilter) {
+	return []string{"buildProvider", "buildSdk", "test"}, []*pathFilter{f.BuildProvider, f.BuildSDK, f.Test}
+}
+
+// actionHooks are the keys of the hook points in actions.
+var actionHooks = []string{"preBuild", "postBuild", "preSdkBuild", "preTest", "postTest", "prePublish", "postPublish", "preRelease"}
+
+// Step is a custom workflow step. Exactly one of Uses or Run must be set.
+type Step struct {
+	Name string `yaml:"name,omitempty"`
+	ID   string `yaml:"id,omitempty"`
+	If   string `yaml:"if,omitempty"`
+	// Uses is an action, pinned to a commit like those in
+	// action-versions.yml, a local action (./path) or a Docker image
+	// (docker://image).
+	Uses             string         `yaml:"uses,omitempty"`
+	Run              string         `yaml:"run,omitempty"`
+	Shell            string         `yaml:"shell,omitempty"`
+	WorkingDirectory string         `yaml:"working-directory,omitempty"`
+	With             map[string]any `yaml:"with,omitempty"`
+	Env              map[string]any `yaml:"env,omitempty"`
+	// ContinueOnError is a boolean or an expression.
+	ContinueOnError any `yaml:"continue-on-error,omitempty"`
+	// TimeoutMinutes is a number or an expression.
+	TimeoutMinutes any `yaml:"timeout-minutes,omitempty"`
+
+	// usesComment is the comment after uses, such as "# v4" after a commit.
+	usesComment string
+}
+
+func (s *Step) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type step Step // Without UnmarshalYAML.
+	if err := unmarshal((*step)(s)); err != nil {
+		return err
+	}
+	var comment struct {
+		Uses yaml.Node `yaml:"uses"`
+		// Other keys were decoded above.
+		Other map[string]any `yaml:",inline"`
+	}
+	if err := unmarshal(&comment); err != nil {
+		return err
+	}
+	s.usesComment = comment.Uses.LineComment
+	return nil
+}
+
+func (s Step) MarshalYAML() (interface{}, error) {
+	type step Step // Without MarshalYAML.
+	var node yaml.Node
+	if err := node.Encode(step(s)); err != nil {
+		return nil, err
+	}
+	if uses := lookupNode(&node, "uses"); uses != nil {
+		uses.LineComment = s.usesComment
+	}
+	return &node, nil
 }
 
 type actionVersions struct {
@@ -501,6 +702,60 @@ type publish struct {
 }
 
 func loadDefaultConfig() (Config, error) {
+	return loadDefaultConfigFrom(embeddedTemplates)
+}
+
+func loadDefaultConfigFrom(templates fs.FS) (Config, error) {
+	config, err := loadActionVersionDefaults()
+	if err != nil {
+		return Config{}, err
+	}
+
+	configBytes, err := fs.ReadFile(templates, defaultsConfigPath)
+	if err != nil {
+		return Config{}, fmt.Errorf("error reading defaults config file: %w", err)
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(configBytes))
+	dec.KnownFields(true)
+	err = dec.Decode(&config)
+	if err != nil {
+		return Config{}, fmt.Errorf("error parsing defaults config file: %w", err)
+	}
+
+	return config, nil
+}
+
+// pinnedActions returns the uses of each action in action-versions.yml, such
+// as "actions/checkout@<commit> # v4", keyed by the action without its ref.
+func pinnedActions() (map[string]string, error) {
+	var doc struct {
+		Jobs map[string]struct {
+			Steps []struct {
+				Uses yaml.Node `yaml:"uses"`
+			} `yaml:"steps"`
+		} `yaml:"jobs"`
+	}
+	if err := yaml.Unmarshal(defaultActionVersions, &doc); err != nil {
+		return nil, fmt.Errorf("error parsing action-versions.yml: %w", err)
+	}
+	pinned := map[string]string{}
+	for _, job := range doc.Jobs {
+		for _, step := range job.Steps {
+			action, _, _ := strings.Cut(step.Uses.Value, "@")
+			uses := step.Uses.Value
+			if step.Uses.LineComment != "" {
+				uses += " " + step.Uses.LineComment
+			}
+			pinned[action] = uses
+		}
+	}
+	return pinned, nil
+}
+
+// loadActionVersionDefaults returns a Config with only the action versions
+// from action-versions.yml set.
+func loadActionVersionDefaults() (Config, error) {
 	var config Config
 
 	// Parse our actions file while preserving comments.
@@ -569,22 +824,6 @@ func loadDefaultConfig() (Config, error) {
 		}
 	}
 
-	configBytes, err := templateFS.ReadFile(filepath.Join("templates", "defaults.config.yaml"))
-	if err != nil {
-		return Config{}, fmt.Errorf("error reading embedded defaults config file: %w", err)
-	}
-
-	dec := yaml.NewDecoder(bytes.NewReader(configBytes))
-	dec.KnownFields(true)
-	err = dec.Decode(&config)
-	if err != nil {
-		return Config{}, fmt.Errorf("error parsing embedded defaults config file: %w", err)
-	}
-
-	if config.ModulePath == "" {
-		config.ModulePath = "provider"
-	}
-
 	return config, nil
 }
 
@@ -623,3 +862,325 @@ func (x *intOrDuration) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	*x = intOrDuration(i * int64(time.Minute))
 	return nil
 }
+
+func (x intOrDuration) MarshalYAML() (interface{}, error) {
+	return time.Duration(x).String(), nil
+}
+
+// maxTimeout is the longest GitHub lets a job run.
+const maxTimeout = 360 * time.Minute
+
+// jobTimeouts overrides timeout for each kind of job, keyed like runner.
+type jobTimeouts struct {
+	Prerequisites intOrDuration `yaml:"prerequisites"`
+	BuildSDK      intOrDuration `yaml:"buildSdk"`
+	Test          intOrDuration `yaml:"test"`
+	Publish       intOrDuration `yaml:"publish"`
+}
+
+// jobTimeoutKinds are the kinds of job in jobTimeouts. Other jobs are of the
+// "default" kind.
+var jobTimeoutKinds = []string{"prerequisites", "buildSdk", "test", "publish"}
+
+// timeoutMinutes returns the timeout-minutes of jobs of the given kind,
+// rounded up to whole minutes, or 0 if they have no timeout.
+func (c Config) timeoutMinutes(kind string) (int, error) {
+	timeout := c.Timeout
+	var override intOrDuration
+	switch kind {
+	case "default":
+	case "prerequisites":
+		override = c.JobTimeouts.Prerequisites
+	case "buildSdk":
+		override = c.JobTimeouts.BuildSDK
+	case "test":
+		override = c.JobTimeouts.Test
+	case "publish":
+		override = c.JobTimeouts.Publish
+	default:
+		return 0, fmt.Errorf("unknown kind of job %q", kind)
+	}
+	if override != 0 {
+		timeout = override
+	}
+	return int((time.Duration(timeout) + time.Minute - 1) / time.Minute), nil
+}
+
+// EnvVar is an entry in env. It is either a string, which is treated as a
+// secret if it mentions "secrets.", or a mapping which declares exactly one of
+// value, secret or esc:
+//
+//	env:
+//	  AWS_REGION: us-west-2
+//	  PULUMI_MISSING_DOCS_ERROR:
+//	    value: "true"
+//	    scope: test
+//	  OSSRH_PASSWORD:
+//	    secret: OSSRH_PASSWORD
+//	    scope: publish
+//	  ARM_CLIENT_SECRET:
+//	    esc: ARM_CLIENT_SECRET
+type EnvVar struct {
+	// Value is a plaintext value, which is never treated as a secret.
+	Value string `yaml:"value,omitempty"`
+	// Secret is the name of a GitHub secret.
+	Secret string `yaml:"secret,omitempty"`
+	// ESC is the name of a secret exported by the ESC environment. It is only
+	// available to test steps, after the secrets are fetched.
+	ESC string `yaml:"esc,omitempty"`
+	// Scope limits which jobs get the variable: "all", the default, or one
+	// kind of job as in jobEnv.
+	Scope EnvScope `yaml:"scope,omitempty"`
+
+	// untyped is set for entries written as a string, whose Value may
+	// reference secrets.
+	untyped bool
+}
+
+// EnvScope limits which jobs get an env entry: every job, or one kind of job.
+type EnvScope string
+
+const (
+	EnvScopeAll             EnvScope = "all"
+	EnvScopePrerequisites   EnvScope = "prerequisites"
+	EnvScopeBuildSDK        EnvScope = "buildSdk"
+	EnvScopeTest            EnvScope = "test"
+	EnvScopePublish         EnvScope = "publish"
+	EnvScopeUpgradeProvider EnvScope = "upgradeProvider"
+)
+
+var envScopes = []string{
+	string(EnvScopeAll),
+	string(EnvScopePrerequisites),
+	string(EnvScopeBuildSDK),
+	string(EnvScopeTest),
+	string(EnvScopePublish),
+	string(EnvScopeUpgradeProvider),
+}
+
+// jobEnv holds env entries for each kind of job, keyed like runner.
+type jobEnv struct {
+	Prerequisites   map[string]EnvVar `yaml:"prerequisites"`
+	BuildSDK        map[string]EnvVar `yaml:"buildSdk"`
+	Test            map[string]EnvVar `yaml:"test"`
+	Publish         map[string]EnvVar `yaml:"publish"`
+	UpgradeProvider map[string]EnvVar `yaml:"upgradeProvider"`
+}
+
+// scoped returns the entries in jobEnv for jobs of the given kind.
+func (j jobEnv) scoped(scope EnvScope) map[string]EnvVar {
+	switch scope {
+	case EnvScopePrerequisites:
+		return j.Prerequisites
+	case EnvScopeBuildSDK:
+		return j.BuildSDK
+	case EnvScopeTest:
+		return j.Test
+	case EnvScopePublish:
+		return j.Publish
+	case EnvScopeUpgradeProvider:
+		return j.UpgradeProvider
+	}
+	return nil
+}
+
+// scopedEnv returns the env entries for jobs of the given kind: those in env
+// with that scope and those in jobEnv, which take precedence.
+func (c Config) scopedEnv(scope EnvScope) map[string]EnvVar {
+	env := map[string]EnvVar{}
+	for k, v := range c.Env {
+		if v.scope() == scope {
+			env[k] = v
+		}
+	}
+	for k, v := range c.JobEnv.scoped(scope) {
+		env[k] = v
+	}
+	return env
+}
+
+func (e *EnvVar) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err == nil {
+		*e = EnvVar{Value: s, untyped: true}
+		return nil
+	}
+
+	type envVar EnvVar // Without UnmarshalYAML.
+	var v envVar
+	if err := unmarshal(&v); err != nil {
+		return err
+	}
+	*e = EnvVar(v)
+	return nil
+}
+
+func (e EnvVar) MarshalYAML() (interface{}, error) {
+	if e.untyped {
+		return e.Value, nil
+	}
+	type envVar EnvVar // Without MarshalYAML.
+	return envVar(e), nil
+}
+
+// MarshalJSON writes the entry as it is written in YAML, e.g. for defaults in
+// the schema.
+func (e EnvVar) MarshalJSON() ([]byte, error) {
+	var node yaml.Node
+	if err := node.Encode(e); err != nil {
+		return nil, err
+	}
+	var v any
+	if err := node.Decode(&v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+// isSecret reports whether the entry holds a secret, so should only be given
+// to the steps which need it when ESC is enabled.
+func (e EnvVar) isSecret() bool {
+	if e.untyped {
+		return strings.Contains(e.Value, "secrets.")
+	}
+	return e.Secret != "" || e.ESC != ""
+}
+
+// scope returns the entry's scope, defaulting to EnvScopeAll.
+func (e EnvVar) scope() EnvScope {
+	if e.Scope == "" {
+		return EnvScopeAll
+	}
+	return e.Scope
+}
+
+// expression returns the entry's value as written in a workflow.
+func (e EnvVar) expression() string {
+	switch {
+	case e.Secret != "":
+		return "${{ secrets." + e.Secret + " }}"
+	case e.ESC != "":
+		return "${{ steps.esc-secrets.outputs." + e.ESC + " }}"
+	}
+	return e.Value
+}
+
+// runner is the runs-on value for a job. It is written as a label, a list of
+// labels which the runner must all have, or a runner group with optional
+// labels:
+//
+//	runner:
+//	  default: ubuntu-latest
+//	  buildSdk: [self-hosted, linux, x64]
+//	  test:
+//	    group: large-runners
+//	    labels: [linux]
+type runner struct {
+	Group  string   `yaml:"group,omitempty" json:"group,omitempty"`
+	Labels []string `yaml:"labels,omitempty" json:"labels,omitempty"`
+}
+
+// testOSes are the operating systems which runner.testOs may list. The test
+// jobs download the provider binary which build_provider.yml built for the
+// runner's OS and architecture.
+var testOSes = []string{"ubuntu", "windows", "macos"}
+
+// linuxOnlyTemplates only build the linux-amd64 provider binary, so their
+// tests can only run on ubuntu.
+var linuxOnlyTemplates = []string{"native", "external-native-provider"}
+
+func (r *runner) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var label string
+	if err := unmarshal(&label); err == nil {
+		*r = runner{Labels: []string{label}}
+		return nil
+	}
+
+	var labels []string
+	if err := unmarshal(&labels); err == nil {
+		*r = runner{Labels: labels}
+		return nil
+	}
+
+	var group struct {
+		Group  string `yaml:"group"`
+		Labels any    `yaml:"labels"`
+	}
+	if err := unmarshal(&group); err != nil {
+		return err
+	}
+	*r = runner{Group: group.Group}
+	switch labels := group.Labels.(type) {
+	case nil:
+	case string:
+		r.Labels = []string{labels}
+	case []any:
+		for _, label := range labels {
+			s, ok := label.(string)
+			if !ok {
+				return fmt.Errorf("runner labels must be strings, got %v", label)
+			}
+			r.Labels = append(r.Labels, s)
+		}
+	default:
+		return fmt.Errorf("runner labels must be a string or a list of strings, got %v", labels)
+	}
+	if r.Group == "" && len(r.Labels) == 0 {
+		return fmt.Errorf("runner must have a group or labels")
+	}
+	return nil
+}
+
+func (r runner) MarshalYAML() (interface{}, error) {
+	switch {
+	case r.Group != "":
+		type group runner // Without MarshalYAML.
+		return group(r), nil
+	case len(r.Labels) == 1:
+		return r.Labels[0], nil
+	}
+	return r.Labels, nil
+}
+
+// RunsOn returns the runner as a runs-on value, with lists and groups written
+// inline. Templates call it rather than printing the runner so an encoding
+// error fails the generation instead of ending up in a workflow.
+func (r runner) RunsOn() (string, error) {
+	var node yaml.Node
+	if err := node.Encode(r); err != nil {
+		return "", fmt.Errorf("error encoding runner: %w", err)
+	}
+	flowStyle(&node)
+	data, err := yaml.Marshal(&node)
+	if err != nil {
+		return "", fmt.Errorf("error encoding runner: %w", err)
+	}
+	return strings.TrimSuffix(string(data), "\n"), nil
+}
+
+// Input returns the runner as the runs-on input of a reusable workflow, which
+// can only be a string. A single label is written as it is, while lists and
+// groups are written as quoted JSON for the workflow to decode with fromJSON.
+func (r runner) Input() (string, error) {
+	if r.Group == "" && len(r.Labels) == 1 {
+		return r.Labels[0], nil
+	}
+	v, err := r.MarshalYAML()
+	if err != nil {
+		return "", fmt.Errorf("error encoding runner: %w", err)
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("error encoding runner: %w", err)
+	}
+	return "'" + string(data) + "'", nil
+}
+
+func flowStyle(node *yaml.Node) {
+	if node.Kind != yaml.ScalarNode {
+		node.Style = yaml.FlowStyle
+	}
+	for _, child := range node.Content {
+		flowStyle(child)
+	}
+}
@@ -0,0 +1,365 @@
+package pkg
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"reflect"
+	"strings"
+
+	"github.com/pulumi/ci-mgmt/provider-ci/internal/pkg/migrations"
+	"gopkg.in/yaml.v3"
+)
+
+// parseConfigKey splits a dotted key into .ci-mgmt.yaml, e.g.
+// "esc.environment", and returns the type of the field it refers to. Keys
+// within maps such as env are accepted as is.
+func parseConfigKey(key string) ([]string, reflect.Type, error) {
+	keys := strings.Split(key, ".")
+	t := reflect.TypeOf(Config{})
+	for i, k := range keys {
+		if k == "" {
+			return nil, nil, fmt.Errorf("invalid config key %q", key)
+		}
+		for t.Kind() == reflect.Pointer {
+			t = t.Elem()
+		}
+		switch t.Kind() {
+		case reflect.Struct:
+			var names []string
+			var field *reflect.StructField
+			for j := range t.NumField() {
+				name, ok := yamlFieldName(t.Field(j))
+				if !ok {
+					continue
+				}
+				names = append(names, name)
+				if name == k {
+					f := t.Field(j)
+					field = &f
+				}
+			}
+			if field == nil {
+				msg := fmt.Sprintf("unknown config key %q", strings.Join(keys[:i+1], "."))
+				if closest := closestName(k, names); closest != "" {
+					msg += fmt.Sprintf(", did you mean %q?", strings.Join(append(keys[:i:i], closest), "."))
+				}
+				return nil, nil, errors.New(msg)
+			}
+			t = field.Type
+		case reflect.Map:
+			t = t.Elem()
+		case reflect.Interface:
+			// Free-form values such as openinspect.settings can contain anything.
+			return keys, t, nil
+		default:
+			return nil, nil, fmt.Errorf("%q is a %s and has no key %q", strings.Join(keys[:i], "."), t.Kind(), k)
+		}
+	}
+	return keys, t, nil
+}
+
+// parseConfigValue parses value as YAML and checks that it can be decoded
+// into a field of type t.
+func parseConfigValue(key, value string, t reflect.Type) (*yaml.Node, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(value), &doc); err != nil {
+		_, msg := splitYAMLError(err.Error())
+		return nil, fmt.Errorf("error parsing value for %s: %s", key, msg)
+	}
+	node := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str"}
+	if len(doc.Content) > 0 {
+		node = doc.Content[0]
+	}
+	if err := checkConfigValue(key, node, t); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+// checkConfigValue checks that node can be decoded into a field of type t.
+// String fields are tagged so values such as "11" stay strings when written.
+func checkConfigValue(key string, node *yaml.Node, t reflect.Type) error {
+	data, err := yaml.Marshal(node)
+	if err != nil {
+		return err
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(reflect.New(t).Interface()); err != nil {
+		var typeErr *yaml.TypeError
+		if !errors.As(err, &typeErr) {
+			_, msg := splitYAMLError(err.Error())
+			return fmt.Errorf("invalid value for %s: %s", key, msg)
+		}
+		var msgs []string
+		for _, e := range typeErr.Errors {
+			_, msg := splitYAMLError(e)
+			msgs = append(msgs, msg)
+		}
+		return fmt.Errorf("invalid value for %s: %s", key, strings.Join(msgs, "; "))
+	}
+	if t.Kind() == reflect.String && node.Kind == yaml.ScalarNode {
+		node.Tag, node.Style = "!!str", 0
+	}
+	blockStyle(node)
+	return nil
+}
+
+// blockStyle writes collections given inline, e.g. [a, b], as blocks to
+// match the rest of the file.
+func blockStyle(node *yaml.Node) {
+	if node.Kind != yaml.ScalarNode {
+		node.Style = 0
+	}
+	for _, child := range node.Content {
+		blockStyle(child)
+	}
+}
+
+// listElements returns the values of a list field parsed from value, which is
+// either a single element or a list of them.
+func listElements(key, value string, t reflect.Type) ([]*yaml.Node, error) {
+	if t.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("%s is not a list", key)
+	}
+	node, err := parseConfigValue(key, value, reflect.SliceOf(t.Elem()))
+	if err == nil && node.Kind == yaml.SequenceNode {
+		return node.Content, nil
+	}
+	node, err = parseConfigValue(key, value, t.Elem())
+	if err != nil {
+		return nil, err
+	}
+	return []*yaml.Node{node}, nil
+}
+
+// GetConfigValue returns the value at the dotted key in the .ci-mgmt.yaml at
+// path, or nil if the file doesn't set it.
+func GetConfigValue(path, key string) (*yaml.Node, error) {
+	keys, _, err := parseConfigKey(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := migrations.OpenConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return f.Get(keys), nil
+}
+
+// GetResolvedConfigValue returns the value at the dotted key which the
+// templates see, as shown by ResolvedConfig.AnnotatedYAML.
+func GetResolvedConfigValue(templates fs.FS, path, key string) (*yaml.Node, error) {
+	keys, _, err := parseConfigKey(key)
+	if err != nil {
+		return nil, err
+	}
+	resolved, err := ResolveConfig(templates, path)
+	if err != nil {
+		return nil, err
+	}
+	resolved.ApplyImplicitDefaults(resolved.Config.Template, RepositoryName(resolved.Config))
+	var root yaml.Node
+	if err := root.Encode(resolved.Config); err != nil {
+		return nil, err
+	}
+	return lookupNode(&root, keys...), nil
+}
+
+// SetConfigValue sets the dotted key in the .ci-mgmt.yaml at path to value,
+// which is parsed as YAML and must suit the field's type. The rest of the
+// file, including its comments, is left as is.
+func SetConfigValue(path, key, value string) error {
+	keys, t, err := parseConfigKey(key)
+	if err != nil {
+		return err
+	}
+	node, err := parseConfigValue(key, value, t)
+	if err != nil {
+		return err
+	}
+	f, err := migrations.OpenConfigFile(path)
+	if err != nil {
+		return err
+	}
+	if err := f.Set(keys, node); err != nil {
+		return err
+	}
+	return f.Write()
+}
+
+// AppendConfigValues appends value, an element or a list of them, to the list
+// at the dotted key, skipping any it already contains. If the file doesn't set
+// the list, the elements are appended to the inherited one with a "key+"
+// entry.
+func AppendConfigValues(path, key, value string) error {
+	keys, t, err := parseConfigKey(key)
+	if err != nil {
+		return err
+	}
+	elements, err := listElements(key, value, t)
+	if err != nil {
+		return err
+	}
+	f, err := migrations.OpenConfigFile(path)
+	if err != nil {
+		return err
+	}
+
+	list := f.Get(keys)
+	if list == nil {
+		list = f.Get(appendKeys(keys))
+	}
+	if list == nil {
+		if err := f.Set(appendKeys(keys), &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Content: elements}); err != nil {
+			return err
+		}
+		return f.Write()
+	}
+	if list.Kind != yaml.SequenceNode {
+		return fmt.Errorf("%s is not a list in %s", key, path)
+	}
+	for _, e := range elements {
+		// Appending is idempotent so it can be repeated across repositories.
+		if !matchesAny(e, list.Content) {
+			list.Content = append(list.Content, e)
+		}
+	}
+	return f.Write()
+}
+
+// RemoveConfigValues removes value, an element or a list of them, from the
+// list at the dotted key and returns how many elements were removed. Elements
+// of lists of mappings, such as plugins, may be given by name. If the file
+// doesn't set the list, the elements are removed from its "key+" entry or,
+// failing that, the inherited list without the elements is set.
+func RemoveConfigValues(templates fs.FS, path, key, value string) (int, error) {
+	keys, t, err := parseConfigKey(key)
+	if err != nil {
+		return 0, err
+	}
+	elements, err := listElements(key, value, t)
+	if err != nil {
+		// Allow removing elements of lists of mappings by name.
+		var nameErr error
+		if elements, nameErr = listElements(key, value, reflect.TypeOf([]string{})); nameErr != nil {
+			return 0, err
+		}
+	}
+	f, err := migrations.OpenConfigFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	if list := f.Get(keys); list != nil {
+		if list.Kind != yaml.SequenceNode {
+			return 0, fmt.Errorf("%s is not a list in %s", key, path)
+		}
+		var removed int
+		list.Content, removed = withoutMatches(list.Content, elements)
+		if removed == 0 {
+			return 0, nil
+		}
+		return removed, f.Write()
+	}
+
+	if appended := f.Get(appendKeys(keys)); appended != nil && appended.Kind == yaml.SequenceNode {
+		if kept, removed := withoutMatches(appended.Content, elements); removed > 0 {
+			appended.Content = kept
+			if len(kept) == 0 {
+				f.Unset(appendKeys(keys))
+			}
+			return removed, f.Write()
+		}
+	}
+
+	// Replace the inherited list, which includes anything appended with
+	// "key+", with a copy without the elements.
+	layers, err := loadConfigLayers(templates, path)
+	if err != nil {
+		return 0, err
+	}
+	merged, err := mergeConfigLayers(layers)
+	if err != nil {
+		return 0, err
+	}
+	list := lookupNode(merged.root, keys...)
+	if list == nil || list.Kind != yaml.SequenceNode {
+		return 0, nil
+	}
+	kept, removed := withoutMatches(list.Content, elements)
+	if removed == 0 {
+		return 0, nil
+	}
+	f.Unset(appendKeys(keys))
+	if err := f.Set(keys, &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Content: kept}); err != nil {
+		return 0, err
+	}
+	return removed, f.Write()
+}
+
+// withoutMatches returns items without those matching elements, along with
+// how many were removed.
+func withoutMatches(items, elements []*yaml.Node) ([]*yaml.Node, int) {
+	var kept []*yaml.Node
+	for _, item := range items {
+		if !matchesAny(item, elements) {
+			kept = append(kept, item)
+		}
+	}
+	return kept, len(items) - len(kept)
+}
+
+// UnsetConfigValue deletes the dotted key, along with anything appended to it
+// with "key+", from the .ci-mgmt.yaml at path so the inherited value applies.
+// It reports whether the file set the key.
+func UnsetConfigValue(path, key string) (bool, error) {
+	keys, _, err := parseConfigKey(key)
+	if err != nil {
+		return false, err
+	}
+	f, err := migrations.OpenConfigFile(path)
+	if err != nil {
+		return false, err
+	}
+	unset := f.Unset(keys)
+	if f.Unset(appendKeys(keys)) {
+		unset = true
+	}
+	if !unset {
+		return false, nil
+	}
+	return true, f.Write()
+}
+
+// appendKeys returns the keys of the "key+" entry which appends to keys.
+func appendKeys(keys []string) []string {
+	return append(keys[:len(keys)-1:len(keys)-1], keys[len(keys)-1]+appendSuffix)
+}
+
+// matchesAny reports whether item equals one of elements, or is a mapping
+// whose name is one of them.
+func matchesAny(item *yaml.Node, elements []*yaml.Node) bool {
+	for _, e := range elements {
+		if e.Kind == yaml.ScalarNode && item.Kind == yaml.MappingNode {
+			if name := lookupNode(item, "name"); name != nil && name.Value == e.Value {
+				return true
+			}
+			continue
+		}
+		if e.Kind == yaml.ScalarNode && item.Kind == yaml.ScalarNode {
+			if e.Value == item.Value {
+				return true
+			}
+			continue
+		}
+		a, errA := yaml.Marshal(item)
+		b, errB := yaml.Marshal(e)
+		if errA == nil && errB == nil && bytes.Equal(a, b) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,144 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func readConfigFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestEditConfigValues(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		".ci-mgmt.yaml": `# yaml-language-server: $schema=ci-mgmt.schema.json
+provider: foo
+# ESC is required for Pulumi providers.
+esc:
+  enabled: true # required
+plugins:
+  - name: random
+    version: "4.0.0"
+`,
+	})
+	path := filepath.Join(dir, ".ci-mgmt.yaml")
+
+	if err := SetConfigValue(path, "esc.environment", "foo/bar"); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetConfigValue(path, "toolVersions.java", "17"); err != nil {
+		t.Fatal(err)
+	}
+	if err := AppendConfigValues(path, "plugins", "{name: tls, version: 5.0.0}"); err != nil {
+		t.Fatal(err)
+	}
+	// Appending again changes nothing.
+	if err := AppendConfigValues(path, "plugins", "{name: tls, version: 5.0.0}"); err != nil {
+		t.Fatal(err)
+	}
+	if err := AppendConfigValues(path, "languages", "[go, java]"); err != nil {
+		t.Fatal(err)
+	}
+	if removed, err := RemoveConfigValues(embeddedTemplates, path, "plugins", "random"); err != nil || removed != 1 {
+		t.Fatalf("expected to remove random, got %d, %v", removed, err)
+	}
+
+	expected := `# yaml-language-server: $schema=ci-mgmt.schema.json
+provider: foo
+# ESC is required for Pulumi providers.
+esc:
+  enabled: true # required
+  environment: foo/bar
+plugins:
+  - name: tls
+    version: 5.0.0
+toolVersions:
+  java: "17"
+languages+:
+  - go
+  - java
+`
+	if got := readConfigFile(t, path); got != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, got)
+	}
+
+	value, err := GetConfigValue(path, "esc.environment")
+	if err != nil || value == nil || value.Value != "foo/bar" {
+		t.Fatalf("expected esc.environment to be foo/bar, got %v, %v", value, err)
+	}
+	if value, err := GetConfigValue(path, "esc.organization"); err != nil || value != nil {
+		t.Fatalf("expected esc.organization to be unset, got %v, %v", value, err)
+	}
+	resolved, err := GetResolvedConfigValue(embeddedTemplates, path, "genName")
+	if err != nil || resolved == nil || resolved.Value != "tfgen" {
+		t.Fatalf("expected the resolved genName to be tfgen, got %v, %v", resolved, err)
+	}
+
+	if unset, err := UnsetConfigValue(path, "languages"); err != nil || !unset {
+		t.Fatalf("expected languages+ to be unset, got %v, %v", unset, err)
+	}
+	if unset, err := UnsetConfigValue(path, "esc.environment"); err != nil || !unset {
+		t.Fatalf("expected esc.environment to be unset, got %v, %v", unset, err)
+	}
+	if unset, err := UnsetConfigValue(path, "docker"); err != nil || unset {
+		t.Fatalf("expected docker to not be set, got %v, %v", unset, err)
+	}
+	if got := readConfigFile(t, path); strings.Contains(got, "languages") || strings.Contains(got, "environment") {
+		t.Fatalf("expected languages and esc.environment to be removed, got:\n%s", got)
+	}
+}
+
+func TestRemoveConfigValuesFromInheritedList(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		".ci-mgmt.yaml": "provider: foo\nlanguages+:\n  - java\n",
+	})
+	path := filepath.Join(dir, ".ci-mgmt.yaml")
+
+	removed, err := RemoveConfigValues(embeddedTemplates, path, "languages", "go")
+	if err != nil || removed != 1 {
+		t.Fatalf("expected to remove go, got %d, %v", removed, err)
+	}
+	expected := `provider: foo
+languages:
+  - nodejs
+  - python
+  - dotnet
+  - java
+  - java
+`
+	if got := readConfigFile(t, path); got != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, got)
+	}
+}
+
+func TestEditConfigValuesChecksKeysAndTypes(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{".ci-mgmt.yaml": "provider: foo\n"})
+	path := filepath.Join(dir, ".ci-mgmt.yaml")
+
+	for _, c := range []struct {
+		key, value, err string
+	}{
+		{"esc.enviroment", "x", `unknown config key "esc.enviroment", did you mean "esc.environment"?`},
+		{"shards", "abc", "invalid value for shards: cannot unmarshal !!str `abc` into int"},
+		{"provider.name", "x", `"provider" is a string and has no key "name"`},
+		{"plugins", "{name: x, bogus: 1}", "invalid value for plugins"},
+	} {
+		err := SetConfigValue(path, c.key, c.value)
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Fatalf("expected setting %s to fail with %q, got %v", c.key, c.err, err)
+		}
+	}
+	if err := AppendConfigValues(path, "shards", "1"); err == nil || err.Error() != "shards is not a list" {
+		t.Fatalf("expected appending to a non-list to fail, got %v", err)
+	}
+	if got := readConfigFile(t, path); got != "provider: foo\n" {
+		t.Fatalf("expected the file to be unchanged, got:\n%s", got)
+	}
+}
@@ -0,0 +1,296 @@
+package pkg
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// InitSource says where a value written by InitConfig came from.
+type InitSource string
+
+const (
+	// InitInferred values were detected in the repository.
+	InitInferred InitSource = "inferred"
+	// InitDefault values are the defaults, written out as a starting point.
+	InitDefault InitSource = "default"
+	// InitRequired values are needed for the config to pass validation.
+	InitRequired InitSource = "required"
+)
+
+// InitValue is a value written by InitConfig.
+type InitValue struct {
+	// Key is the dotted path of the value, e.g. "esc.enabled".
+	Key    string
+	Value  any
+	Source InitSource
+	// Reason explains how an inferred or required value was chosen.
+	Reason string
+}
+
+// InitResult is a starting .ci-mgmt.yaml for a repository.
+type InitResult struct {
+	Values []InitValue
+	// Content is the config, with each value commented with its source.
+	Content []byte
+}
+
+// InitConfig inspects the provider repository in dir and returns a starting
+// .ci-mgmt.yaml for it:
+//
+//   - provider, organization and major-version from the module path in
+//     provider/go.mod, or go.mod if the module is at the root
+//   - template from whether provider/resources.go uses the Terraform bridge
+//     and, for other providers, whether they look native (see
+//     isNativeProvider)
+//   - modulePath from where go.mod is
+//   - languages from the subdirectories of sdk
+//   - docker from whether testing/docker-compose.yml exists
+//
+// Anything which can't be inferred is left to its default.
+func InitConfig(dir string) (*InitResult, error) {
+	defaults, err := loadDefaultConfig()
+	if err != nil {
+		return nil, err
+	}
+	var values []InitValue
+	inferred := func(key string, value any, reason string) {
+		values = append(values, InitValue{Key: key, Value: value, Source: InitInferred, Reason: reason})
+	}
+	defaulted := func(key string, value any) {
+		values = append(values, InitValue{Key: key, Value: value, Source: InitDefault})
+	}
+
+	goMod, modulePath, err := findGoMod(dir)
+	if err != nil {
+		return nil, err
+	}
+	module := ""
+	if goMod != "" {
+		mod, err := readGoMod(filepath.Join(dir, goMod))
+		if err != nil {
+			return nil, err
+		}
+		module = mod.Module
+	}
+
+	organization, provider, majorVersion := parseProviderModule(module)
+	if provider != "" {
+		inferred("provider", provider, "from the module path in "+goMod)
+	} else {
+		provider = strings.TrimPrefix(filepath.Base(absPath(dir)), "pulumi-")
+		inferred("provider", provider, "from the directory name")
+	}
+	if organization != "" && organization != defaults.Organization {
+		inferred("organization", organization, "from the module path in "+goMod)
+	}
+	if organization == "" {
+		organization = defaults.Organization
+	}
+	if majorVersion > 0 {
+		inferred("major-version", majorVersion, "from the module path in "+goMod)
+	} else {
+		defaulted("major-version", defaults.MajorVersion)
+	}
+
+	thirdParty := organization != "pulumi"
+	bridged, err := usesTerraformBridge(dir, modulePath)
+	if err != nil {
+		return nil, err
+	}
+	native := false
+	if goMod != "" && !bridged {
+		if native, err = isNativeProvider(dir, goMod, provider); err != nil {
+			return nil, err
+		}
+	}
+	schema := filepath.Join(modulePath, "cmd", "pulumi-resource-"+provider, "schema.json")
+	switch {
+	case bridged && thirdParty:
+		inferred("template", "external-bridged-provider", filepath.Join(modulePath, "resources.go")+" uses the Terraform bridge")
+	case bridged:
+		inferred("template", "bridged-provider", filepath.Join(modulePath, "resources.go")+" uses the Terraform bridge")
+	case goMod != "" && thirdParty:
+		inferred("template", "external-native-provider", filepath.Join(modulePath, "resources.go")+" doesn't use the Terraform bridge")
+	case native:
+		inferred("template", "native", schema+" is checked in, there's no upstream submodule and "+goMod+" doesn't require the Terraform bridge")
+	case goMod != "":
+		inferred("template", "generic", filepath.Join(modulePath, "resources.go")+" doesn't use the Terraform bridge")
+	default:
+		defaulted("template", defaults.Template)
+	}
+
+	if goMod != "" {
+		inferred("modulePath", modulePath, "from the location of "+goMod)
+	}
+
+	var languages []string
+	for _, language := range supportedLanguages {
+		if isDir(filepath.Join(dir, "sdk", language)) {
+			languages = append(languages, language)
+		}
+	}
+	if len(languages) > 0 {
+		inferred("languages", languages, "from the directories in sdk")
+	} else {
+		defaulted("languages", defaults.Languages)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "testing", "docker-compose.yml")); err == nil {
+		inferred("docker", true, "testing/docker-compose.yml exists")
+	} else {
+		defaulted("docker", defaults.Docker)
+	}
+
+	defaulted("lint", defaults.Lint)
+	if !thirdParty {
+		values = append(values, InitValue{Key: "esc.enabled", Value: true, Source: InitRequired, Reason: "for repositories in the pulumi organization"})
+	}
+
+	content, err := renderInitConfig(values)
+	if err != nil {
+		return nil, err
+	}
+	return &InitResult{Values: values, Content: content}, nil
+}
+
+// renderInitConfig writes values as a .ci-mgmt.yaml with a comment after each
+// naming where it came from.
+func renderInitConfig(values []InitValue) ([]byte, error) {
+	root := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	root.HeadComment = "yaml-language-server: $schema=" + schemaURL + `
+Written by provider-ci init. Inferred values were detected in the repository
+and the rest are defaults; check them before running provider-ci generate.`
+
+	for _, v := range values {
+		value := &yaml.Node{}
+		if err := value.Encode(v.Value); err != nil {
+			return nil, err
+		}
+		comment := string(v.Source)
+		if v.Reason != "" {
+			comment += ": " + v.Reason
+		}
+
+		mapping := root
+		keys := strings.Split(v.Key, ".")
+		for _, key := range keys[:len(keys)-1] {
+			next := lookupNode(mapping, key)
+			if next == nil {
+				next = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+				mapping.Content = append(mapping.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, next)
+			}
+			mapping = next
+		}
+		key := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: keys[len(keys)-1]}
+		if value.Kind == yaml.ScalarNode {
+			value.LineComment = comment
+		} else {
+			// Comments on block collections are written after the key.
+			key.LineComment = comment
+		}
+		mapping.Content = append(mapping.Content, key, value)
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(root); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// findGoMod returns the path of the provider's go.mod relative to dir and the
+// directory containing it: provider/go.mod, or go.mod if the module is at the
+// root. If there is neither, it returns "" and the default directory.
+func findGoMod(dir string) (string, string, error) {
+	for _, modulePath := range []string{"provider", "."} {
+		goMod := filepath.Join(modulePath, "go.mod")
+		_, err := os.Stat(filepath.Join(dir, goMod))
+		if err == nil {
+			return goMod, modulePath, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return "", "", err
+		}
+	}
+	return "", "provider", nil
+}
+
+// parseProviderModule splits a provider's module path, e.g.
+// github.com/pulumi/pulumi-aws/provider/v7, into its organization, provider
+// name and major version. A module without a /vN suffix is version 1. Parts
+// which can't be determined are returned empty.
+func parseProviderModule(module string) (organization, provider string, majorVersion int) {
+	parts := strings.Split(module, "/")
+	if len(parts) < 3 || parts[0] != "github.com" {
+		return "", "", 0
+	}
+	organization = parts[1]
+	provider = strings.TrimPrefix(parts[2], "pulumi-")
+	majorVersion = 1
+	if last := parts[len(parts)-1]; len(parts) > 3 && strings.HasPrefix(last, "v") {
+		if n, err := strconv.Atoi(last[1:]); err == nil && n >= 2 {
+			majorVersion = n
+		}
+	}
+	return organization, provider, majorVersion
+}
+
+// usesTerraformBridge reports whether resources.go in the provider's module
+// imports the Terraform bridge.
+func usesTerraformBridge(dir, modulePath string) (bool, error) {
+	data, err := os.ReadFile(filepath.Join(dir, modulePath, "resources.go"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return bytes.Contains(data, []byte("github.com/pulumi/pulumi-terraform-bridge")), nil
+}
+
+// isNativeProvider reports whether the module at goMod looks like a native
+// provider: its schema is checked in beside its plugin, there's no upstream
+// submodule and it doesn't require the Terraform bridge.
+func isNativeProvider(dir, goMod, provider string) (bool, error) {
+	modulePath := filepath.Dir(goMod)
+	schema := filepath.Join(dir, modulePath, "cmd", "pulumi-resource-"+provider, "schema.json")
+	if _, err := os.Stat(schema); errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if isDir(filepath.Join(dir, "upstream")) {
+		return false, nil
+	}
+	data, err := os.ReadFile(filepath.Join(dir, goMod))
+	if err != nil {
+		return false, err
+	}
+	return !bytes.Contains(data, []byte("github.com/pulumi/pulumi-terraform-bridge")), nil
+}
+
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// absPath returns path made absolute, or path itself if that fails.
+func absPath(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	return abs
+}
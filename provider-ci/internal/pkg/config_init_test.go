@@ -0,0 +1,138 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func initConfigValues(t *testing.T, dir string) (map[string]InitValue, string) {
+	t.Helper()
+	result, err := InitConfig(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := map[string]InitValue{}
+	for _, v := range result.Values {
+		values[v.Key] = v
+	}
+
+	// The config must pass validation as written.
+	configPath := filepath.Join(dir, ".ci-mgmt.yaml")
+	if err := os.WriteFile(configPath, result.Content, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	diags, err := ValidateConfig(embeddedTemplates, configPath, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diags) > 0 {
+		t.Fatalf("expected the config to be valid, got %v:\n%s", diags, result.Content)
+	}
+	return values, string(result.Content)
+}
+
+func TestInitConfigInfersBridgedProvider(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"provider/go.mod":            "module github.com/pulumi/pulumi-foo/provider/v3\n\ngo 1.22\n",
+		"provider/resources.go":      "package foo\n\nimport \"github.com/pulumi/pulumi-terraform-bridge/v3/pkg/tfbridge\"\n",
+		"sdk/nodejs/package.json":    "{}",
+		"sdk/go/foo/provider.go":     "package foo\n",
+		"testing/docker-compose.yml": "services: {}\n",
+	})
+
+	values, content := initConfigValues(t, dir)
+	for key, expected := range map[string]any{
+		"provider":      "foo",
+		"major-version": 3,
+		"template":      "bridged-provider",
+		"modulePath":    "provider",
+		"docker":        true,
+	} {
+		if v := values[key]; v.Value != expected || v.Source != InitInferred {
+			t.Fatalf("expected %s to be inferred as %v, got %+v", key, expected, v)
+		}
+	}
+	if v := values["languages"]; v.Source != InitInferred || strings.Join(v.Value.([]string), ",") != "nodejs,go" {
+		t.Fatalf("expected languages to be inferred from sdk, got %+v", v)
+	}
+	if v := values["lint"]; v.Source != InitDefault {
+		t.Fatalf("expected lint to be a default, got %+v", v)
+	}
+	for _, line := range []string{
+		"provider: foo # inferred: from the module path in provider/go.mod\n",
+		"languages: # inferred: from the directories in sdk\n  - nodejs\n  - go\n",
+		"lint: true # default\n",
+		"esc:\n  enabled: true # required: for repositories in the pulumi organization\n",
+	} {
+		if !strings.Contains(content, line) {
+			t.Fatalf("expected the config to contain %q, got:\n%s", line, content)
+		}
+	}
+}
+
+func TestInitConfigInfersNativeProvider(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"provider/go.mod": "module github.com/pulumi/pulumi-foo/provider\n\ngo 1.22\n\nrequire github.com/pulumi/pulumi-go-provider v1.0.0\n",
+		"provider/cmd/pulumi-resource-foo/schema.json": "{}",
+		"provider/cmd/pulumi-resource-foo/main.go":     "package main\n",
+		"sdk/python/pyproject.toml":                    "",
+	})
+
+	values, _ := initConfigValues(t, dir)
+	if v := values["template"]; v.Value != "native" || v.Source != InitInferred {
+		t.Fatalf("expected the native template to be inferred, got %+v", v)
+	}
+
+	// A schema alongside an upstream submodule belongs to a bridged provider.
+	if err := os.MkdirAll(filepath.Join(dir, "upstream"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	values, _ = initConfigValues(t, dir)
+	if v := values["template"]; v.Value != "generic" {
+		t.Fatalf("expected the generic template with an upstream submodule, got %+v", v)
+	}
+}
+
+func TestInitConfigInfersThirdPartyModuleAtRoot(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"go.mod": "module github.com/acme/pulumi-widget\n\ngo 1.22\n",
+	})
+
+	values, content := initConfigValues(t, dir)
+	for key, expected := range map[string]any{
+		"provider":      "widget",
+		"organization":  "acme",
+		"major-version": 1,
+		"template":      "external-native-provider",
+		"modulePath":    ".",
+	} {
+		if v := values[key]; v.Value != expected || v.Source != InitInferred {
+			t.Fatalf("expected %s to be inferred as %v, got %+v", key, expected, v)
+		}
+	}
+	if _, ok := values["esc.enabled"]; ok || strings.Contains(content, "esc:") {
+		t.Fatalf("expected ESC to be left out for a third-party provider, got:\n%s", content)
+	}
+}
+
+func TestInitConfigDefaultsWithoutGoModule(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "pulumi-empty")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	values, _ := initConfigValues(t, dir)
+	if v := values["provider"]; v.Value != "empty" || v.Reason != "from the directory name" {
+		t.Fatalf("expected the provider to come from the directory name, got %+v", v)
+	}
+	for _, key := range []string{"major-version", "template", "languages", "docker", "lint"} {
+		if v := values[key]; v.Source != InitDefault {
+			t.Fatalf("expected %s to be a default, got %+v", key, v)
+		}
+	}
+	if _, ok := values["modulePath"]; ok {
+		t.Fatal("expected modulePath to be left to its default")
+	}
+}
@@ -0,0 +1,349 @@
+package pkg
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// defaultsConfigPath is the first config layer, within the template set.
+const defaultsConfigPath = "defaults.config.yaml"
+
+// presetFS contains the named config presets which a .ci-mgmt.yaml may extend.
+//
+//go:embed presets
+var presetFS embed.FS
+
+// presetPrefix marks layers which come from presets.
+const presetPrefix = "preset:"
+
+// appendSuffix marks a key whose list is appended to the inherited list
+// rather than replacing it, e.g. "plugins+".
+const appendSuffix = "+"
+
+// replacedMappings are the mappings whose entries are mappings which later
+// layers replace as a whole rather than merge, e.g. an env entry declaring a
+// secret which a later layer redeclares as a plaintext value, or a runner
+// group replaced by another.
+var replacedMappings = map[string]bool{
+	"env":                    true,
+	"envOverride":            true,
+	"jobEnv.prerequisites":   true,
+	"jobEnv.buildSdk":        true,
+	"jobEnv.test":            true,
+	"jobEnv.publish":         true,
+	"jobEnv.upgradeProvider": true,
+	"runner":                 true,
+}
+
+// configLayer is a YAML document which contributes to a provider's config. A
+// .ci-mgmt.yaml is layered over the files and presets it extends, which are
+// layered over the template set's defaults.
+type configLayer struct {
+	// name identifies the layer: defaults.config.yaml, preset:<name> or the
+	// path of a file.
+	name string
+	// root is the document's top-level mapping without its `extends` key.
+	root *yaml.Node
+	// extends is the `extends` key's value, if any.
+	extends *yaml.Node
+}
+
+// isFile reports whether the layer was read from a provider's repository.
+func (l configLayer) isFile() bool {
+	return isFileLayer(l.name)
+}
+
+// isFileLayer reports whether the layer with the given name was read from a
+// provider's repository.
+func isFileLayer(name string) bool {
+	return name != defaultsConfigPath && !strings.HasPrefix(name, presetPrefix)
+}
+
+// layerError is a problem reading a layer, positioned in the file which
+// referenced or contained it.
+type layerError struct {
+	path         string
+	line, column int
+	msg          string
+}
+
+func (e *layerError) Error() string {
+	if e.column > 0 {
+		return fmt.Sprintf("%s:%d:%d: %s", e.path, e.line, e.column, e.msg)
+	}
+	return fmt.Sprintf("%s:%d: %s", e.path, e.line, e.msg)
+}
+
+// ListPresets returns the names of the presets a .ci-mgmt.yaml may extend.
+func ListPresets() ([]string, error) {
+	entries, err := fs.ReadDir(presetFS, "presets")
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, strings.TrimSuffix(entry.Name(), ".yaml"))
+	}
+	return names, nil
+}
+
+// loadConfigLayers returns the layers of the config at path in the order
+// they apply: the defaults, then everything path extends depth-first, then
+// path itself. A layer extended more than once is only applied the first
+// time.
+func loadConfigLayers(templates fs.FS, path string) ([]configLayer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file %s: %w", path, err)
+	}
+	return loadConfigLayersData(templates, path, data)
+}
+
+// loadConfigLayersData is loadConfigLayers with the content of the config at
+// path given as data, which need not have been written yet.
+func loadConfigLayersData(templates fs.FS, path string, data []byte) ([]configLayer, error) {
+	defaults, err := fs.ReadFile(templates, defaultsConfigPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading defaults config file: %w", err)
+	}
+	defaultsLayer, err := parseConfigLayer(defaultsConfigPath, defaults)
+	if err != nil {
+		return nil, err
+	}
+
+	l := layerLoader{seen: map[string]bool{}}
+	l.layers = append(l.layers, defaultsLayer)
+	if err := l.load(path, data); err != nil {
+		return nil, err
+	}
+	return l.layers, nil
+}
+
+type layerLoader struct {
+	layers []configLayer
+	seen   map[string]bool
+	// stack holds the layers currently being loaded, to detect cycles.
+	stack []string
+}
+
+func (l *layerLoader) load(name string, data []byte) error {
+	layer, err := parseConfigLayer(name, data)
+	if err != nil {
+		return err
+	}
+	l.seen[name] = true
+	l.stack = append(l.stack, name)
+	defer func() { l.stack = l.stack[:len(l.stack)-1] }()
+
+	if layer.extends != nil {
+		if layer.extends.Kind != yaml.SequenceNode {
+			return layerAt(name, layer.extends, "extends must be a list of files and presets")
+		}
+		for _, entry := range layer.extends.Content {
+			if err := l.loadExtended(layer, entry); err != nil {
+				return err
+			}
+		}
+	}
+	l.layers = append(l.layers, layer)
+	return nil
+}
+
+func (l *layerLoader) loadExtended(from configLayer, entry *yaml.Node) error {
+	ref := entry.Value
+	var name string
+	var data []byte
+	if strings.HasSuffix(ref, ".yaml") || strings.HasSuffix(ref, ".yml") {
+		if !from.isFile() {
+			return layerAt(from.name, entry, fmt.Sprintf("presets can only extend other presets, not %q", ref))
+		}
+		name = filepath.Join(filepath.Dir(from.name), filepath.FromSlash(ref))
+		d, err := os.ReadFile(name)
+		if err != nil {
+			return layerAt(from.name, entry, fmt.Sprintf("error reading %s: %v", ref, err))
+		}
+		data = d
+	} else {
+		name = presetPrefix + ref
+		d, err := fs.ReadFile(presetFS, path.Join("presets", ref+".yaml"))
+		if err != nil {
+			presets, _ := ListPresets()
+			return layerAt(from.name, entry, fmt.Sprintf("unknown preset %q, expected a .yaml file or one of: %s", ref, strings.Join(presets, ", ")))
+		}
+		data = d
+	}
+
+	for _, loading := range l.stack {
+		if loading == name {
+			return layerAt(from.name, entry, fmt.Sprintf("extending %s creates a cycle: %s -> %s", ref, strings.Join(l.stack, " -> "), name))
+		}
+	}
+	if l.seen[name] {
+		return nil
+	}
+	return l.load(name, data)
+}
+
+func layerAt(path string, node *yaml.Node, msg string) *layerError {
+	return &layerError{path: path, line: node.Line, column: node.Column, msg: msg}
+}
+
+// parseConfigLayer parses a config document, splitting out its extends key.
+func parseConfigLayer(name string, data []byte) (configLayer, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		line, msg := splitYAMLError(err.Error())
+		return configLayer{}, &layerError{path: name, line: line, msg: msg}
+	}
+	layer := configLayer{name: name, root: &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Line: 1, Column: 1}}
+	if len(doc.Content) == 0 {
+		return layer, nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return configLayer{}, layerAt(name, root, "expected a mapping of configuration keys")
+	}
+	layer.root = &yaml.Node{Kind: yaml.MappingNode, Tag: root.Tag, Line: root.Line, Column: root.Column}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == "extends" {
+			layer.extends = root.Content[i+1]
+			continue
+		}
+		layer.root.Content = append(layer.root.Content, root.Content[i], root.Content[i+1])
+	}
+	return layer, nil
+}
+
+// mergedConfig is the result of merging config layers.
+type mergedConfig struct {
+	// root is the merged top-level mapping. Nodes which came unchanged from
+	// a layer are shared with it and keep their positions.
+	root *yaml.Node
+	// sources maps the dotted path of each value to the layers which set
+	// it. Only appended lists have more than one.
+	sources map[string][]string
+	// layers maps each node in root to the layer it came from.
+	layers map[*yaml.Node]string
+}
+
+// mergeConfigLayers merges layers in order. Mappings are merged key by key,
+// except within replacedMappings, and anything else, including lists, is
+// replaced by later layers. A key with the "+" suffix appends its list to the
+// inherited one instead.
+func mergeConfigLayers(layers []configLayer) (*mergedConfig, error) {
+	m := &mergedConfig{
+		root:    &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"},
+		sources: map[string][]string{},
+		layers:  map[*yaml.Node]string{},
+	}
+	for _, layer := range layers {
+		m.root.Line, m.root.Column = layer.root.Line, layer.root.Column
+		m.layers[m.root] = layer.name
+		if err := m.mergeMapping(m.root, layer.root, layer.name, ""); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func (m *mergedConfig) mergeMapping(into, from *yaml.Node, layer, prefix string) error {
+	for i := 0; i+1 < len(from.Content); i += 2 {
+		key, value := from.Content[i], from.Content[i+1]
+		name, appending := strings.CutSuffix(key.Value, appendSuffix)
+		p := prefix + name
+
+		existing := -1
+		for j := 0; j+1 < len(into.Content); j += 2 {
+			if into.Content[j].Value == name {
+				existing = j + 1
+			}
+		}
+
+		switch {
+		case appending:
+			if value.Kind != yaml.SequenceNode {
+				return layerAt(layer, key, fmt.Sprintf("%s can only append a list", key.Value))
+			}
+			if existing >= 0 && into.Content[existing].Kind == yaml.SequenceNode {
+				inherited := into.Content[existing]
+				appended := &yaml.Node{Kind: yaml.SequenceNode, Tag: value.Tag, Line: value.Line, Column: value.Column}
+				appended.Content = append(append(appended.Content, inherited.Content...), value.Content...)
+				into.Content[existing] = appended
+				m.layers[appended] = layer
+				m.sources[p] = append(m.sources[p], layer)
+				continue
+			}
+			m.set(into, existing, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: name, Line: key.Line, Column: key.Column}, value, layer, p)
+		case value.Kind == yaml.MappingNode && existing >= 0 && into.Content[existing].Kind == yaml.MappingNode && !replacedMappings[strings.TrimSuffix(prefix, ".")]:
+			// Copy the inherited mapping so layers are never modified.
+			inherited := into.Content[existing]
+			merged := &yaml.Node{Kind: yaml.MappingNode, Tag: value.Tag, Line: value.Line, Column: value.Column}
+			merged.Content = append(merged.Content, inherited.Content...)
+			into.Content[existing] = merged
+			m.layers[merged] = layer
+			if err := m.mergeMapping(merged, value, layer, p+"."); err != nil {
+				return err
+			}
+		default:
+			m.set(into, existing, key, value, layer, p)
+		}
+	}
+	return nil
+}
+
+// set sets a key in a mapping, replacing the value at index existing if it
+// is not -1, and attributes it and everything within it to layer.
+func (m *mergedConfig) set(into *yaml.Node, existing int, key, value *yaml.Node, layer, p string) {
+	if existing >= 0 {
+		into.Content[existing-1], into.Content[existing] = key, value
+	} else {
+		into.Content = append(into.Content, key, value)
+	}
+	for source := range m.sources {
+		if source == p || strings.HasPrefix(source, p+".") {
+			delete(m.sources, source)
+		}
+	}
+	m.attribute(value, layer, p)
+}
+
+func (m *mergedConfig) attribute(node *yaml.Node, layer, p string) {
+	m.layers[node] = layer
+	if node.Kind != yaml.MappingNode || len(node.Content) == 0 {
+		m.sources[p] = []string{layer}
+		for _, child := range node.Content {
+			m.attributeNodes(child, layer)
+		}
+		return
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		m.layers[node.Content[i]] = layer
+		m.attribute(node.Content[i+1], layer, p+"."+node.Content[i].Value)
+	}
+}
+
+func (m *mergedConfig) attributeNodes(node *yaml.Node, layer string) {
+	m.layers[node] = layer
+	for _, child := range node.Content {
+		m.attributeNodes(child, layer)
+	}
+}
+
+// decode decodes the merged config onto config, rejecting unknown fields.
+func (m *mergedConfig) decode(config *Config) error {
+	data, err := yaml.Marshal(m.root)
+	if err != nil {
+		return err
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	return dec.Decode(config)
+}
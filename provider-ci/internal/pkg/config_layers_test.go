@@ -0,0 +1,181 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeConfigFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestResolveConfigMergesExtendedLayers(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"shared/base.yaml": `plugins:
+  - name: random
+    version: "4.0.0"
+env:
+  FOO: base
+  BAR: base
+  QUX:
+    secret: QUX
+languages: [nodejs, python]
+`,
+		".ci-mgmt.yaml": `provider: foo
+extends:
+  - large-provider
+  - shared/base.yaml
+env:
+  BAR: local
+  QUX:
+    value: local
+plugins+:
+  - name: tls
+    version: "5.0.0"
+languages: [go]
+`,
+	})
+	configPath := filepath.Join(dir, ".ci-mgmt.yaml")
+
+	resolved, err := ResolveConfig(embeddedTemplates, configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedLayers := []string{defaultsConfigPath, "preset:large-provider", filepath.Join(dir, "shared", "base.yaml"), configPath}
+	if !reflect.DeepEqual(resolved.Layers, expectedLayers) {
+		t.Fatalf("expected layers %v, got %v", expectedLayers, resolved.Layers)
+	}
+
+	config := resolved.Config
+	if !config.FreeDiskSpaceBeforeBuild {
+		t.Fatal("expected freeDiskSpaceBeforeBuild from the large-provider preset")
+	}
+	if config.Env["FOO"].Value != "base" || config.Env["BAR"].Value != "local" || config.Env["QUX"] != (EnvVar{Value: "local"}) {
+		t.Fatalf("expected env to be merged key by key with each entry replaced, got %v", config.Env)
+	}
+	if !reflect.DeepEqual(config.Languages, []string{"go"}) {
+		t.Fatalf("expected languages to be replaced, got %v", config.Languages)
+	}
+	var plugins []string
+	for _, p := range config.Plugins {
+		plugins = append(plugins, p.Name)
+	}
+	if !reflect.DeepEqual(plugins, []string{"random", "tls"}) {
+		t.Fatalf("expected plugins+ to append, got %v", plugins)
+	}
+
+	if sources := resolved.Sources("env.BAR"); !reflect.DeepEqual(sources, []string{configPath}) {
+		t.Fatalf("expected env.BAR to come from %s, got %v", configPath, sources)
+	}
+	if sources := resolved.Sources("plugins"); len(sources) != 2 {
+		t.Fatalf("expected plugins to come from two layers, got %v", sources)
+	}
+	if sources := resolved.Sources("freeDiskSpaceBeforeTest"); !reflect.DeepEqual(sources, []string{"preset:large-provider"}) {
+		t.Fatalf("expected freeDiskSpaceBeforeTest to come from the preset, got %v", sources)
+	}
+
+	annotated, err := resolved.AnnotatedYAML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(annotated), "freeDiskSpaceBeforeBuild: true # preset:large-provider") {
+		t.Fatalf("expected annotated YAML to attribute the preset, got:\n%s", annotated)
+	}
+}
+
+func TestLoadLocalConfigMatchesResolvedConfig(t *testing.T) {
+	// The kubernetes-component preset carries everything the component
+	// providers share, so extending it reproduces the full config.
+	expected, err := LoadLocalConfig(filepath.Join("..", "..", "test-providers", "kubernetes-coredns", ".ci-mgmt.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := writeConfigFiles(t, map[string]string{
+		".ci-mgmt.yaml": `extends:
+  - kubernetes-component
+provider: kubernetes-coredns
+setupKind: true
+esc:
+  environment: imports/github-secrets
+`,
+	})
+	config, err := LoadLocalConfig(filepath.Join(dir, ".ci-mgmt.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(config, expected) {
+		t.Fatalf("expected the kubernetes-component preset to reproduce the kubernetes-coredns config:\nexpected %+v\ngot      %+v", expected, config)
+	}
+}
+
+func TestResolveConfigDetectsCycles(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"a.yaml":        "extends: [b.yaml]\n",
+		"b.yaml":        "extends: [a.yaml]\n",
+		".ci-mgmt.yaml": "provider: foo\nextends: [a.yaml]\n",
+	})
+
+	_, err := ResolveConfig(embeddedTemplates, filepath.Join(dir, ".ci-mgmt.yaml"))
+	if err == nil || !strings.Contains(err.Error(), "creates a cycle") {
+		t.Fatalf("expected a cycle error, got %v", err)
+	}
+}
+
+func TestValidateConfigReportsExtendsProblems(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		".ci-mgmt.yaml": `provider: foo
+extends:
+  - no-such-preset
+`,
+	})
+	configPath := filepath.Join(dir, ".ci-mgmt.yaml")
+
+	diags, err := ValidateConfig(embeddedTemplates, configPath, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diags) != 1 || diags[0].Path != configPath || diags[0].Line != 3 || diags[0].Column != 5 ||
+		!strings.Contains(diags[0].Message, `unknown preset "no-such-preset"`) {
+		t.Fatalf("expected an unknown preset error at 3:5, got %v", diags)
+	}
+}
+
+func TestValidateConfigReportsProblemsInExtendedFiles(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"base.yaml": "lnit: true\n",
+		".ci-mgmt.yaml": `provider: foo
+extends: [base.yaml]
+esc+:
+  enabled: true
+`,
+	})
+
+	diags, err := ValidateConfig(embeddedTemplates, filepath.Join(dir, ".ci-mgmt.yaml"), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diags) != 2 {
+		t.Fatalf("expected 2 diagnostics, got %v", diags)
+	}
+	if diags[0].Path != filepath.Join(dir, ".ci-mgmt.yaml") || diags[0].Line != 3 {
+		t.Fatalf("expected esc+ to be rejected, got %v", diags[0])
+	}
+	if diags[1].Path != filepath.Join(dir, "base.yaml") || diags[1].Line != 1 || diags[1].Suggestion != `did you mean "lint"?` {
+		t.Fatalf("expected the unknown field in base.yaml, got %v", diags[1])
+	}
+}
@@ -0,0 +1,220 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// actionVersionsSource attributes values which come from action-versions.yml.
+const actionVersionsSource = "action-versions.yml"
+
+// ResolvedConfig is a provider's config along with where each value came
+// from.
+type ResolvedConfig struct {
+	Config Config
+	// Layers are the names of the layers which were merged, in order.
+	Layers []string
+	layers []configLayer
+	// sources maps the dotted path of each value to what set it.
+	sources map[string][]string
+}
+
+// ResolveConfig loads the config at path like LoadLocalConfigFrom and
+// records which layer set each value.
+func ResolveConfig(templates fs.FS, path string) (*ResolvedConfig, error) {
+	layers, err := loadConfigLayers(templates, path)
+	if err != nil {
+		return nil, err
+	}
+	merged, err := mergeConfigLayers(layers)
+	if err != nil {
+		return nil, err
+	}
+	config, err := loadActionVersionDefaults()
+	if err != nil {
+		return nil, err
+	}
+	if err := merged.decode(&config); err != nil {
+		return nil, fmt.Errorf("error parsing config file %s: %w", path, err)
+	}
+
+	resolved := &ResolvedConfig{layers: layers, sources: map[string][]string{}}
+	for _, layer := range layers {
+		resolved.Layers = append(resolved.Layers, layer.name)
+	}
+	for p, sources := range merged.sources {
+		resolved.sources[p] = sources
+	}
+	actionVersions, err := actionVersionPaths()
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range actionVersions {
+		if _, ok := resolved.sources[p]; !ok {
+			resolved.sources[p] = []string{actionVersionsSource}
+		}
+	}
+
+	// Merge envOverride into config.Env (overriding specific keys rather than replacing all)
+	if config.EnvOverride != nil {
+		for k, v := range config.EnvOverride {
+			config.Env[k] = v
+			var sources []string
+			for _, source := range resolved.sources["envOverride."+k] {
+				sources = append(sources, source+" (envOverride)")
+			}
+			resolved.sources["env."+k] = sources
+		}
+	}
+
+	resolved.Config = config
+	return resolved, nil
+}
+
+// ApplyImplicitDefaults applies ApplyImplicitDefaults to the config as
+// GeneratePackage would for templateName and repositoryName, and records
+// each default as the source of its value.
+func (r *ResolvedConfig) ApplyImplicitDefaults(templateName, repositoryName string) {
+	for _, d := range ApplyImplicitDefaults(&r.Config, templateName, repositoryName) {
+		r.sources[d.Path] = []string{"implicit default: " + d.Reason}
+	}
+}
+
+// Sources returns what set the value at the dotted path, e.g. "esc.enabled":
+// the layers which set it, action-versions.yml or an implicit default. Values
+// which were never set have no sources.
+func (r *ResolvedConfig) Sources(p string) []string {
+	return r.sources[p]
+}
+
+// SourcePaths returns every dotted path with a recorded source, sorted.
+func (r *ResolvedConfig) SourcePaths() []string {
+	var paths []string
+	for p := range r.sources {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// AnnotatedYAML renders every field of the config with a comment after each
+// value naming what set it. Fields without a comment were never set.
+func (r *ResolvedConfig) AnnotatedYAML() ([]byte, error) {
+	var node yaml.Node
+	if err := node.Encode(r.Config); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(annotateSources(&node, "", r.sources)); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// resolvedJSON is the JSON form of a ResolvedConfig. Config uses the same
+// field names as .ci-mgmt.yaml.
+type resolvedJSON struct {
+	Layers  []string            `json:"layers"`
+	Config  any                 `json:"config"`
+	Sources map[string][]string `json:"sources"`
+}
+
+// JSON renders every field of the config along with the layers and what set
+// each value.
+func (r *ResolvedConfig) JSON() ([]byte, error) {
+	var node yaml.Node
+	if err := node.Encode(r.Config); err != nil {
+		return nil, err
+	}
+	var config any
+	if err := node.Decode(&config); err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(resolvedJSON{Layers: r.Layers, Config: config, Sources: r.sources}, "", "  ")
+}
+
+// annotateSources copies node, which is at the dotted path p, with each
+// value's line comment naming its sources. Values in lists are not
+// attributed separately from the list.
+func annotateSources(node *yaml.Node, p string, sources map[string][]string) *yaml.Node {
+	out := *node
+	out.Content = nil
+	if node.Kind != yaml.MappingNode || len(node.Content) == 0 {
+		out.Content = node.Content
+		if p != "" {
+			out.LineComment = strings.Join(sources[p], " + ")
+		}
+		return &out
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key := *node.Content[i]
+		child := key.Value
+		if p != "" {
+			child = p + "." + key.Value
+		}
+		value := annotateSources(node.Content[i+1], child, sources)
+		if value.Kind != yaml.ScalarNode && len(value.Content) > 0 {
+			// Comments on block collections are written after the key.
+			key.LineComment, value.LineComment = value.LineComment, ""
+		}
+		out.Content = append(out.Content, &key, value)
+	}
+	return &out
+}
+
+// actionVersionPaths returns the dotted paths of the values set from
+// action-versions.yml.
+func actionVersionPaths() ([]string, error) {
+	config, err := loadActionVersionDefaults()
+	if err != nil {
+		return nil, err
+	}
+	var defaults, zero yaml.Node
+	if err := defaults.Encode(config); err != nil {
+		return nil, err
+	}
+	if err := zero.Encode(Config{}); err != nil {
+		return nil, err
+	}
+	zeroValues := map[string]string{}
+	scalarPaths(&zero, "", zeroValues)
+	values := map[string]string{}
+	scalarPaths(&defaults, "", values)
+
+	var paths []string
+	for p, v := range values {
+		if zeroValues[p] != v {
+			paths = append(paths, p)
+		}
+	}
+	return paths, nil
+}
+
+// scalarPaths records the value of each scalar within the mappings of node
+// by its dotted path.
+func scalarPaths(node *yaml.Node, p string, values map[string]string) {
+	if node.Kind != yaml.MappingNode {
+		if node.Kind == yaml.ScalarNode {
+			values[p] = node.Value
+		}
+		return
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		child := node.Content[i].Value
+		if p != "" {
+			child = p + "." + child
+		}
+		scalarPaths(node.Content[i+1], child, values)
+	}
+}
@@ -0,0 +1,99 @@
+package pkg
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestResolvedConfigAttributesEveryLayer(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		".ci-mgmt.yaml": `provider: provider-boilerplate
+template: generic
+modulePath: provider
+env:
+  FOO: local
+envOverride:
+  BAR: override
+`,
+	})
+	configPath := filepath.Join(dir, ".ci-mgmt.yaml")
+
+	resolved, err := ResolveConfig(embeddedTemplates, configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolved.ApplyImplicitDefaults(resolved.Config.Template, RepositoryName(resolved.Config))
+
+	config := resolved.Config
+	if config.ModulePath != "." || config.GenName != "gen" || config.Env["BAR"].Value != "override" {
+		t.Fatalf("expected the boilerplate module path, generic gen name and env override, got %+v", config)
+	}
+
+	expected := map[string][]string{
+		"provider":                    {configPath},
+		"organization":                {defaultsConfigPath},
+		"env.BAR":                     {configPath + " (envOverride)"},
+		"actionVersions.checkout":     {actionVersionsSource},
+		"genName":                     {"implicit default: default for the generic template"},
+		"modulePath":                  {"implicit default: pulumi-provider-boilerplate keeps its module at the root"},
+		"toolVersions.pulumictl":      {"implicit default: default pulumictl version"},
+		"runner.default":              {defaultsConfigPath},
+		"freeDiskSpaceBeforeSdkBuild": {defaultsConfigPath},
+	}
+	for p, sources := range expected {
+		if got := resolved.Sources(p); !reflect.DeepEqual(got, sources) {
+			t.Fatalf("expected %s to come from %v, got %v", p, sources, got)
+		}
+	}
+	if sources := resolved.Sources("repository"); sources != nil {
+		t.Fatalf("expected repository to be unset, got %v", sources)
+	}
+
+	annotated, err := resolved.AnnotatedYAML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"genName: gen # implicit default: default for the generic template",
+		"  FOO: local # " + configPath,
+		"repository: \"\"\n",
+	} {
+		if !strings.Contains(string(annotated), line) {
+			t.Fatalf("expected annotated YAML to contain %q, got:\n%s", line, annotated)
+		}
+	}
+
+	out, err := resolved.JSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded struct {
+		Layers  []string
+		Config  map[string]any
+		Sources map[string][]string
+	}
+	if err := json.Unmarshal(out, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Config["modulePath"] != "." || !reflect.DeepEqual(decoded.Sources["modulePath"], expected["modulePath"]) {
+		t.Fatalf("expected JSON to include the config and its sources, got %s", out)
+	}
+	if !reflect.DeepEqual(decoded.Layers, []string{defaultsConfigPath, configPath}) {
+		t.Fatalf("expected JSON to include the layers, got %v", decoded.Layers)
+	}
+}
+
+func TestApplyImplicitDefaultsKeepsConfiguredValues(t *testing.T) {
+	config := Config{GenName: "custom", TestFolder: "tests", MaintenanceReleaseDay: 15, ModulePath: "src"}
+	applied := ApplyImplicitDefaults(&config, "bridged-provider", "pulumi/pulumi-foo")
+
+	if len(applied) != 1 || applied[0].Path != "toolVersions.pulumictl" {
+		t.Fatalf("expected only pulumictl to be defaulted, got %v", applied)
+	}
+	if config.GenName != "custom" || config.TestFolder != "tests" || config.MaintenanceReleaseDay != 15 || config.ModulePath != "src" {
+		t.Fatalf("expected configured values to be kept, got %+v", config)
+	}
+}
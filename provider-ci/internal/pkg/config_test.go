@@ -1,11 +1,19 @@
 package pkg
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"reflect"
+	"slices"
 	"strings"
 	"testing"
+
+	"gopkg.in/yaml.v3"
 )
 
 func TestLoadLocalConfigDefaultsMajorProviderUpgradesEnabled(t *testing.T) {
@@ -63,8 +71,33 @@ disableAgenticWorkflows: true
 	}
 }
 
+func TestLoadLocalConfigWarnsAboutDeprecatedFields(t *testing.T) {
+	dir := t.TempDir()
+
+	configPath := filepath.Join(dir, ".ci-mgmt.yaml")
+	if err := os.WriteFile(configPath, []byte(`provider: command
+template: native
+parallel: 3
+  # has no effect
+team: ecosystem
+`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var warnings strings.Builder
+	if _, err := LoadLocalConfigFrom(embeddedTemplates, configPath, &warnings); err != nil {
+		t.Fatal(err)
+	}
+	expected := configPath + `:5:1: warning: "team" is deprecated and will be removed after 2027-01: it has no effect
+    fix: remove "team"; running provider-ci generate removes it automatically
+`
+	if warnings.String() != expected {
+		t.Fatalf("expected warnings:\n%s\ngot:\n%s", expected, warnings.String())
+	}
+}
+
 func TestGeneratePackageRendersOpenInspectSettings(t *testing.T) {
-	outDir := t.TempDir()
+	fsys := NewMemFS()
 
 	config, err := loadDefaultConfig()
 	if err != nil {
@@ -79,9 +112,9 @@ func TestGeneratePackageRendersOpenInspectSettings(t *testing.T) {
 		},
 	}
 
-	if err := GeneratePackage(GenerateOpts{
+	if _, err := GeneratePackage(GenerateOpts{
 		RepositoryName: "pulumi/pulumi-aws",
-		OutDir:         outDir,
+		FS:             fsys,
 		TemplateName:   "native",
 		Config:         config,
 		SkipMigrations: true,
@@ -89,7 +122,7 @@ func TestGeneratePackageRendersOpenInspectSettings(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	data, err := os.ReadFile(filepath.Join(outDir, ".openinspect", "settings.json"))
+	data, err := fs.ReadFile(fsys, ".openinspect/settings.json")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -148,7 +181,7 @@ func TestGeneratePackageDeletesLegacyClaudeFiles(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if err := GeneratePackage(GenerateOpts{
+	if _, err := GeneratePackage(GenerateOpts{
 		RepositoryName: "pulumi/pulumi-aws",
 		OutDir:         outDir,
 		TemplateName:   "bridged-provider",
@@ -203,7 +236,7 @@ func TestGeneratePackageDeletesLegacyAgenticWorkflowFiles(t *testing.T) {
 		}
 	}
 
-	if err := GeneratePackage(GenerateOpts{
+	if _, err := GeneratePackage(GenerateOpts{
 		RepositoryName: "pulumi/pulumi-aws",
 		OutDir:         outDir,
 		TemplateName:   "bridged-provider",
@@ -250,7 +283,7 @@ func TestGeneratePackageDeletesLegacyProviderPrefixedAgenticWorkflows(t *testing
 		}
 	}
 
-	if err := GeneratePackage(GenerateOpts{
+	if _, err := GeneratePackage(GenerateOpts{
 		RepositoryName: "pulumi/pulumi-aws",
 		OutDir:         outDir,
 		TemplateName:   "bridged-provider",
@@ -268,7 +301,7 @@ func TestGeneratePackageDeletesLegacyProviderPrefixedAgenticWorkflows(t *testing
 }
 
 func TestGeneratePackageUsesUpgradeProviderRunner(t *testing.T) {
-	outDir := t.TempDir()
+	fsys := NewMemFS()
 
 	config, err := loadDefaultConfig()
 	if err != nil {
@@ -276,11 +309,11 @@ func TestGeneratePackageUsesUpgradeProviderRunner(t *testing.T) {
 	}
 	config.Provider = "aws"
 	config.ESC.Enabled = true
-	config.Runner.UpgradeProvider = "ubuntu-24.04"
+	config.Runner.UpgradeProvider = &runner{Labels: []string{"ubuntu-24.04"}}
 
-	if err := GeneratePackage(GenerateOpts{
+	if _, err := GeneratePackage(GenerateOpts{
 		RepositoryName: "pulumi/pulumi-aws",
-		OutDir:         outDir,
+		FS:             fsys,
 		TemplateName:   "bridged-provider",
 		Config:         config,
 		SkipMigrations: true,
@@ -288,7 +321,7 @@ func TestGeneratePackageUsesUpgradeProviderRunner(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	workflow, err := os.ReadFile(filepath.Join(outDir, ".github/workflows/upgrade-provider.yml"))
+	workflow, err := fs.ReadFile(fsys, ".github/workflows/upgrade-provider.yml")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -296,3 +329,1100 @@ func TestGeneratePackageUsesUpgradeProviderRunner(t *testing.T) {
 		t.Fatalf("expected upgrade-provider workflow to use custom runner, got:\n%s", workflow)
 	}
 }
+
+func TestGeneratePackageRendersRunners(t *testing.T) {
+	config, err := loadDefaultConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Provider = "aws"
+	config.ESC.Enabled = true
+	config.MaintenanceBranch = "v6"
+	if err := yaml.Unmarshal([]byte(`
+buildSdk: [self-hosted, linux]
+lint: [self-hosted, linux]
+maintenance:
+  group: maintenance-runners
+testOs: [ubuntu]
+`), &config.Runner); err != nil {
+		t.Fatal(err)
+	}
+
+	fsys := NewMemFS()
+	if _, err := GeneratePackage(GenerateOpts{
+		RepositoryName: "pulumi/pulumi-aws",
+		TemplateName:   "bridged-provider",
+		Config:         config,
+		FS:             fsys,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	type workflow struct {
+		Jobs map[string]struct {
+			RunsOn   any               `yaml:"runs-on"`
+			With     map[string]string `yaml:"with"`
+			Strategy struct {
+				Matrix map[string]any `yaml:"matrix"`
+			} `yaml:"strategy"`
+		} `yaml:"jobs"`
+	}
+	read := func(path string) workflow {
+		t.Helper()
+		data, err := fs.ReadFile(fsys, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var w workflow
+		if err := yaml.Unmarshal(data, &w); err != nil {
+			t.Fatalf("expected valid YAML, got %v:\n%s", err, data)
+		}
+		return w
+	}
+
+	test := read(".github/workflows/test.yml").Jobs["test"]
+	if test.RunsOn != "${{ matrix.os }}" || !reflect.DeepEqual(test.Strategy.Matrix["os"], []any{"ubuntu-latest"}) {
+		t.Fatalf("expected tests to run on each OS, got runs-on %v and matrix %v", test.RunsOn, test.Strategy.Matrix)
+	}
+	// Reusable workflow inputs are strings, so lists are passed as JSON.
+	if compare := read(".github/workflows/master.yml").Jobs["compare_sdk"]; compare.With["runs-on"] != `["self-hosted","linux"]` {
+		t.Fatalf("expected compare_sdk to get the labels as JSON, got %q", compare.With["runs-on"])
+	}
+	if lint := read(".github/workflows/lint.yml").Jobs["lint"]; !reflect.DeepEqual(lint.RunsOn, []any{"self-hosted", "linux"}) {
+		t.Fatalf("expected lint to run on the labels, got %v", lint.RunsOn)
+	}
+	for _, job := range read(".github/workflows/maintenance-scan.yml").Jobs {
+		if !reflect.DeepEqual(job.RunsOn, map[string]any{"group": "maintenance-runners"}) {
+			t.Fatalf("expected maintenance to run in the group, got %v", job.RunsOn)
+		}
+	}
+}
+
+func TestGeneratePackageRendersTestOSMatrix(t *testing.T) {
+	config, err := loadDefaultConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Provider = "aws"
+	config.ESC.Enabled = true
+	config.Runner.TestOS = []string{"ubuntu", "windows", "macos"}
+
+	fsys := NewMemFS()
+	if _, err := GeneratePackage(GenerateOpts{
+		RepositoryName: "pulumi/pulumi-aws",
+		TemplateName:   "bridged-provider",
+		Config:         config,
+		FS:             fsys,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := fs.ReadFile(fsys, ".github/workflows/test.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var test struct {
+		Jobs map[string]struct {
+			RunsOn   any `yaml:"runs-on"`
+			Strategy struct {
+				Matrix map[string]any `yaml:"matrix"`
+			} `yaml:"strategy"`
+		} `yaml:"jobs"`
+	}
+	if err := yaml.Unmarshal(data, &test); err != nil {
+		t.Fatalf("expected valid YAML, got %v:\n%s", err, data)
+	}
+	job := test.Jobs["test"]
+	expected := []any{"ubuntu-latest", "windows-latest", "macos-latest"}
+	if job.RunsOn != "${{ matrix.os }}" || !reflect.DeepEqual(job.Strategy.Matrix["os"], expected) {
+		t.Fatalf("expected tests to run on each OS, got runs-on %v and matrix %v", job.RunsOn, job.Strategy.Matrix)
+	}
+
+	// Each test job downloads the binary built for its runner.
+	action, err := fs.ReadFile(fsys, ".github/actions/download-provider/action.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"macOS) os=darwin ;;",
+		"Windows) os=windows ;;",
+		"ARM64) arch=arm64 ;;",
+		"pattern: pulumi-resource-aws-*-${{ steps.platform.outputs.platform }}.tar.gz",
+		`-name "pulumi-*-aws.exe"`,
+	} {
+		if !strings.Contains(string(action), want) {
+			t.Fatalf("expected download-provider to contain %q, got:\n%s", want, action)
+		}
+	}
+	if strings.Contains(string(action), "linux-amd64") {
+		t.Fatalf("expected download-provider not to hard-code linux-amd64, got:\n%s", action)
+	}
+}
+
+func TestGeneratePackageRendersTimeouts(t *testing.T) {
+	config, err := loadDefaultConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Provider = "aws"
+	config.ESC.Enabled = true
+	if err := yaml.Unmarshal([]byte(`
+timeout: 90
+jobTimeouts:
+  test: 2h30m
+  publish: 10m30s
+`), &config); err != nil {
+		t.Fatal(err)
+	}
+
+	fsys := NewMemFS()
+	if _, err := GeneratePackage(GenerateOpts{
+		RepositoryName: "pulumi/pulumi-aws",
+		TemplateName:   "bridged-provider",
+		Config:         config,
+		FS:             fsys,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for path, expected := range map[string]map[string]int{
+		".github/workflows/build_sdk.yml": {"build_sdk": 90},
+		".github/workflows/test.yml":      {"test": 150},
+		".github/workflows/publish.yml":   {"publish": 11, "publish_sdk": 11, "create_docs_build": 90, "clean_up_release_labels": 90},
+		".github/workflows/license.yml":   {"license_check": 90},
+	} {
+		data, err := fs.ReadFile(fsys, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var workflow struct {
+			Jobs map[string]struct {
+				TimeoutMinutes int `yaml:"timeout-minutes"`
+			} `yaml:"jobs"`
+		}
+		if err := yaml.Unmarshal(data, &workflow); err != nil {
+			t.Fatalf("expected valid YAML in %s, got %v", path, err)
+		}
+		for job, minutes := range expected {
+			if got := workflow.Jobs[job].TimeoutMinutes; got != minutes {
+				t.Fatalf("expected %s in %s to time out after %d minutes, got %d", job, path, minutes, got)
+			}
+		}
+	}
+}
+
+func TestGeneratePackageRendersActionHooks(t *testing.T) {
+	for templateName, workflows := range map[string]map[string]string{
+		"bridged-provider": {
+			".github/workflows/build_sdk.yml": "build_sdk",
+			".github/workflows/test.yml":      "test",
+			".github/workflows/publish.yml":   "publish_sdk",
+		},
+		"native": {
+			".github/workflows/build.yml":                "build_sdks",
+			".github/workflows/run-acceptance-tests.yml": "test",
+			".github/workflows/release.yml":              "publish_sdk",
+		},
+	} {
+		t.Run(templateName, func(t *testing.T) {
+			config, err := loadDefaultConfig()
+			if err != nil {
+				t.Fatal(err)
+			}
+			config.Provider = "aws"
+			config.ESC.Enabled = true
+			hook := []Step{{Name: "Custom hook", Run: "make custom", Env: map[string]any{"DEBUG": true}}}
+			config.Actions.PreSDKBuild = hook
+			config.Actions.PostTest = hook
+			config.Actions.PostPublish = hook
+
+			fsys := NewMemFS()
+			if _, err := GeneratePackage(GenerateOpts{
+				RepositoryName: "pulumi/pulumi-aws",
+				TemplateName:   templateName,
+				Config:         config,
+				FS:             fsys,
+				SkipMigrations: true,
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			for path, job := range workflows {
+				data, err := fs.ReadFile(fsys, path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var workflow struct {
+					Jobs map[string]struct {
+						Steps []Step `yaml:"steps"`
+					} `yaml:"jobs"`
+				}
+				if err := yaml.Unmarshal(data, &workflow); err != nil {
+					t.Fatalf("expected valid YAML in %s, got %v", path, err)
+				}
+				if !slices.ContainsFunc(workflow.Jobs[job].Steps, func(s Step) bool { return reflect.DeepEqual(s, hook[0]) }) {
+					t.Fatalf("expected %s in %s to run the hook, got %+v", job, path, workflow.Jobs[job].Steps)
+				}
+			}
+		})
+	}
+}
+
+func TestGeneratePackageRendersStepsAsWritten(t *testing.T) {
+	config, err := loadDefaultConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Provider = "aws"
+	config.ESC.Enabled = true
+	if err := yaml.Unmarshal([]byte(`
+actions:
+  preTest:
+    - name: Set up Node
+      uses: actions/setup-node@820762786026740c76f36085b0efc47a31fe5020 # v7.0.0
+      continue-on-error: true
+      timeout-minutes: 5
+`), &config); err != nil {
+		t.Fatal(err)
+	}
+
+	fsys := NewMemFS()
+	if _, err := GeneratePackage(GenerateOpts{
+		RepositoryName: "pulumi/pulumi-aws",
+		TemplateName:   "bridged-provider",
+		Config:         config,
+		FS:             fsys,
+		SkipMigrations: true,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := fs.ReadFile(fsys, ".github/workflows/test.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"uses: actions/setup-node@820762786026740c76f36085b0efc47a31fe5020 # v7.0.0",
+		"continue-on-error: true",
+		"timeout-minutes: 5",
+	} {
+		if !strings.Contains(string(data), line) {
+			t.Fatalf("expected %q in test.yml, got:\n%s", line, data)
+		}
+	}
+}
+
+func TestGeneratePackageRendersPathFilters(t *testing.T) {
+	for templateName, jobs := range map[string]map[string]string{
+		"bridged-provider": {
+			"prerequisites": "buildProvider",
+			"build_sdk":     "buildSdk",
+			"test":          "test",
+		},
+		"native": {
+			"prerequisites": "buildProvider",
+			"build_sdks":    "buildSdk",
+			"test":          "test",
+		},
+	} {
+		t.Run(templateName, func(t *testing.T) {
+			config, err := loadDefaultConfig()
+			if err != nil {
+				t.Fatal(err)
+			}
+			config.Provider = "aws"
+			config.ESC.Enabled = true
+			if err := yaml.Unmarshal([]byte(`
+pathFilters:
+  buildSdk:
+    include: ["provider/**", "sdk/**"]
+  test:
+    include: ["examples/**"]
+    exclude: ["examples/**/*.md"]
+`), &config); err != nil {
+				t.Fatal(err)
+			}
+
+			fsys := NewMemFS()
+			if _, err := GeneratePackage(GenerateOpts{
+				RepositoryName: "pulumi/pulumi-aws",
+				TemplateName:   templateName,
+				Config:         config,
+				FS:             fsys,
+				SkipMigrations: true,
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			path := ".github/workflows/run-acceptance-tests.yml"
+			data, err := fs.ReadFile(fsys, path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			type job struct {
+				If      string            `yaml:"if"`
+				Needs   any               `yaml:"needs"`
+				Outputs map[string]string `yaml:"outputs"`
+				Steps   []Step            `yaml:"steps"`
+			}
+			var workflow struct {
+				Jobs map[string]job `yaml:"jobs"`
+			}
+			if err := yaml.Unmarshal(data, &workflow); err != nil {
+				t.Fatalf("expected valid YAML in %s, got %v", path, err)
+			}
+
+			changes, ok := workflow.Jobs["changes"]
+			if !ok {
+				t.Fatalf("expected a changes job in %s", path)
+			}
+			if got := changes.Outputs["buildProvider"]; got != "true" {
+				t.Fatalf("expected buildProvider to always run, got %q", got)
+			}
+			if got, want := changes.Outputs["buildSdk"], "${{ github.event_name != 'pull_request' || steps.filter.outputs.buildSdk == 'true' || steps.filter.outputs.test == 'true' }}"; got != want {
+				t.Fatalf("expected buildSdk output %q, got %q", want, got)
+			}
+			var filters map[string][]string
+			if err := yaml.Unmarshal([]byte(changes.Steps[0].With["filters"].(string)), &filters); err != nil {
+				t.Fatal(err)
+			}
+			if want := map[string][]string{
+				"buildSdk": {"{provider/**,sdk/**}"},
+				"test":     {"examples/**", "!examples/**/*.md"},
+			}; !reflect.DeepEqual(filters, want) {
+				t.Fatalf("expected filters %v, got %v", want, filters)
+			}
+
+			for name, kind := range jobs {
+				if condition := workflow.Jobs[name].If; !strings.Contains(condition, "needs.changes.outputs."+kind+" == 'true'") {
+					t.Fatalf("expected %s to run when %s changes, got %q", name, kind, condition)
+				}
+			}
+			if condition := workflow.Jobs["sentinel"].If; !strings.Contains(condition, "!cancelled()") {
+				t.Fatalf("expected sentinel to pass when jobs are skipped, got %q", condition)
+			}
+		})
+	}
+}
+
+func TestGeneratePackageRendersConcurrency(t *testing.T) {
+	for templateName, workflows := range map[string]map[string]string{
+		"bridged-provider": {
+			".github/workflows/run-acceptance-tests.yml": "${{ github.workflow }}-${{ github.event.pull_request.number }}",
+			".github/workflows/master.yml":               "${{ github.workflow }}-${{ github.ref }}-${{ github.sha }}",
+			".github/workflows/release.yml":              "release-${{ github.sha }}",
+			".github/workflows/prerelease.yml":           "release-${{ github.sha }}",
+			".github/workflows/upgrade-provider.yml":     "${{ github.workflow }}-${{ github.ref }}",
+		},
+		"native": {
+			".github/workflows/run-acceptance-tests.yml": "${{ github.workflow }}-${{ github.event.pull_request.number }}",
+			".github/workflows/build.yml":                "${{ github.workflow }}-${{ github.ref }}-${{ github.sha }}",
+			".github/workflows/release.yml":              "release-${{ github.sha }}",
+		},
+	} {
+		t.Run(templateName, func(t *testing.T) {
+			config, err := loadDefaultConfig()
+			if err != nil {
+				t.Fatal(err)
+			}
+			config.Provider = "aws"
+			config.ESC.Enabled = true
+			if err := yaml.Unmarshal([]byte(`
+concurrency:
+  pullRequest:
+    group: ${{ github.workflow }}-${{ github.event.pull_request.number }}
+    cancelInProgress: true
+  main: {}
+  release:
+    group: release-${{ github.sha }}
+  upgrade:
+    cancelInProgress: true
+`), &config); err != nil {
+				t.Fatal(err)
+			}
+
+			fsys := NewMemFS()
+			if _, err := GeneratePackage(GenerateOpts{
+				RepositoryName: "pulumi/pulumi-aws",
+				TemplateName:   templateName,
+				Config:         config,
+				FS:             fsys,
+				SkipMigrations: true,
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			for path, group := range workflows {
+				data, err := fs.ReadFile(fsys, path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var workflow struct {
+					Concurrency struct {
+						Group            string `yaml:"group"`
+						CancelInProgress bool   `yaml:"cancel-in-progress"`
+					} `yaml:"concurrency"`
+				}
+				if err := yaml.Unmarshal(data, &workflow); err != nil {
+					t.Fatalf("expected valid YAML in %s, got %v", path, err)
+				}
+				if got := workflow.Concurrency.Group; got != group {
+					t.Fatalf("expected concurrency group %q in %s, got %q", group, path, got)
+				}
+				cancel := strings.Contains(path, "run-acceptance-tests") || strings.Contains(path, "upgrade")
+				if got := workflow.Concurrency.CancelInProgress; got != cancel {
+					t.Fatalf("expected cancel-in-progress %v in %s, got %v", cancel, path, got)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckPackageReportsDriftWithoutWriting(t *testing.T) {
+	outDir := t.TempDir()
+
+	config, err := loadDefaultConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Provider = "aws"
+	config.ESC.Enabled = true
+
+	opts := GenerateOpts{
+		RepositoryName: "pulumi/pulumi-aws",
+		OutDir:         outDir,
+		TemplateName:   "bridged-provider",
+		Config:         config,
+		SkipMigrations: true,
+	}
+	if _, err := GeneratePackage(opts); err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := CheckPackage(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Fatalf("expected no changes after generating, got %#v", changes)
+	}
+
+	makefile := filepath.Join(outDir, "Makefile")
+	if err := os.WriteFile(makefile, []byte("edited by hand\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(outDir, ".github", "workflows", "lint.yml")); err != nil {
+		t.Fatal(err)
+	}
+	stale := filepath.Join(outDir, ".github", "workflows", "stale.yml")
+	if err := os.WriteFile(stale, []byte("stale\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	addToManifest(t, outDir, ".github/workflows/stale.yml", []byte("stale\n"))
+
+	changes, err = CheckPackage(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []FileChange{
+		{Path: ".ci-mgmt.manifest.json", Action: FileUpdated, Source: "manifest"},
+		{Path: ".github/workflows/lint.yml", Action: FileCreated, Source: "all"},
+		{Path: ".github/workflows/stale.yml", Action: FileDeleted, Source: "cleanGithubWorkflows"},
+		{Path: "Makefile", Action: FileUpdated, Source: "base"},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Fatalf("expected %#v, got %#v", expected, changes)
+	}
+
+	data, err := os.ReadFile(makefile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "edited by hand\n" {
+		t.Fatalf("expected check to leave Makefile untouched, got:\n%s", data)
+	}
+	if _, err := os.Stat(stale); err != nil {
+		t.Fatalf("expected check to leave stale workflow in place, got err %v", err)
+	}
+}
+
+func TestGeneratePackageManifestPrunesPreviouslyGeneratedFiles(t *testing.T) {
+	outDir := t.TempDir()
+
+	config, err := loadDefaultConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Provider = "aws"
+	config.ESC.Enabled = true
+	// Only the manifest decides what to prune.
+	config.CleanGithubWorkflows = false
+
+	opts := GenerateOpts{
+		RepositoryName: "pulumi/pulumi-aws",
+		OutDir:         outDir,
+		TemplateName:   "bridged-provider",
+		Config:         config,
+		SkipMigrations: true,
+	}
+	if _, err := GeneratePackage(opts); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := readManifest(os.DirFS(outDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m == nil || m.Files["Makefile"] == "" {
+		t.Fatalf("expected manifest to record the Makefile, got %#v", m)
+	}
+
+	files := map[string]string{
+		// Generated by a previous version and unchanged since.
+		".github/workflows/removed.yml": "removed\n",
+		// Generated by a previous version, then edited by hand.
+		".github/workflows/edited.yml": "edited\n",
+		// Never generated; must survive even without the provider prefix.
+		".github/workflows/custom.yml": "custom\n",
+	}
+	for path, content := range files {
+		if err := os.WriteFile(filepath.Join(outDir, path), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	addToManifest(t, outDir, ".github/workflows/removed.yml", []byte("removed\n"))
+	addToManifest(t, outDir, ".github/workflows/edited.yml", []byte("original\n"))
+
+	if _, err := GeneratePackage(opts); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(outDir, ".github/workflows/removed.yml")); !os.IsNotExist(err) {
+		t.Fatalf("expected removed.yml to be pruned, got err %v", err)
+	}
+	for _, path := range []string{".github/workflows/edited.yml", ".github/workflows/custom.yml"} {
+		if _, err := os.Stat(filepath.Join(outDir, path)); err != nil {
+			t.Fatalf("expected %s to be preserved, got err %v", path, err)
+		}
+	}
+
+	m, err = readManifest(os.DirFS(outDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.Files[".github/workflows/removed.yml"]; ok {
+		t.Fatal("expected removed.yml to be dropped from the manifest")
+	}
+	if _, ok := m.Files[".github/workflows/custom.yml"]; ok {
+		t.Fatal("expected custom.yml to remain unmanaged")
+	}
+}
+
+func TestGeneratePackageManifestKeepsConfiguredDeletions(t *testing.T) {
+	outDir := t.TempDir()
+
+	config, err := loadDefaultConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Provider = "aws"
+	config.ESC.Enabled = true
+
+	opts := GenerateOpts{
+		RepositoryName: "pulumi/pulumi-aws",
+		OutDir:         outDir,
+		TemplateName:   "bridged-provider",
+		Config:         config,
+		SkipMigrations: true,
+	}
+	if _, err := GeneratePackage(opts); err != nil {
+		t.Fatal(err)
+	}
+
+	// Neither file is listed in the manifest written above.
+	for _, path := range []string{".github/workflows/aws-pr-review.md", ".github/workflows/custom.yml"} {
+		if err := os.WriteFile(filepath.Join(outDir, path), []byte("stale\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := GeneratePackage(opts); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(outDir, ".github/workflows/aws-pr-review.md")); !os.IsNotExist(err) {
+		t.Fatalf("expected aws-pr-review.md to be deleted with a manifest present, got err %v", err)
+	}
+	// cleanGithubWorkflows only deletes workflows the manifest lists.
+	if _, err := os.Stat(filepath.Join(outDir, ".github/workflows/custom.yml")); err != nil {
+		t.Fatalf("expected custom.yml to be kept with a manifest present, got err %v", err)
+	}
+}
+
+func TestGeneratePackageManifestPreservesHandEditedFiles(t *testing.T) {
+	outDir := t.TempDir()
+
+	config, err := loadDefaultConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Provider = "aws"
+	config.ESC.Enabled = true
+
+	opts := GenerateOpts{
+		RepositoryName: "pulumi/pulumi-aws",
+		OutDir:         outDir,
+		TemplateName:   "bridged-provider",
+		Config:         config,
+		SkipMigrations: true,
+	}
+	if _, err := GeneratePackage(opts); err != nil {
+		t.Fatal(err)
+	}
+
+	makefile := filepath.Join(outDir, "Makefile")
+	if err := os.WriteFile(makefile, []byte("edited by hand\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	stale := filepath.Join(outDir, ".github", "workflows", "stale.yml")
+	if err := os.WriteFile(stale, []byte("edited by hand\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	addToManifest(t, outDir, ".github/workflows/stale.yml", []byte("stale\n"))
+
+	result, err := GeneratePackage(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var skipped []FileChange
+	for _, f := range result.Files {
+		if f.Action == FileSkipped {
+			skipped = append(skipped, f)
+		}
+	}
+	expected := []FileChange{
+		{Path: ".github/workflows/stale.yml", Action: FileSkipped, Source: sourceCleanGithubWorkflows},
+		{Path: "Makefile", Action: FileSkipped, Source: "base"},
+	}
+	if !reflect.DeepEqual(skipped, expected) {
+		t.Fatalf("expected skipped files %#v, got %#v", expected, skipped)
+	}
+	if _, err := os.Stat(stale); err != nil {
+		t.Fatalf("expected hand-edited stale.yml to be preserved, got err %v", err)
+	}
+	data, err := os.ReadFile(makefile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "edited by hand\n" {
+		t.Fatalf("expected hand-edited Makefile to be preserved, got:\n%s", data)
+	}
+
+	opts.Force = true
+	if _, err := GeneratePackage(opts); err != nil {
+		t.Fatal(err)
+	}
+	data, err = os.ReadFile(makefile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) == "edited by hand\n" {
+		t.Fatal("expected --force to overwrite the hand-edited Makefile")
+	}
+}
+
+func TestGeneratePackageManifestKeepsVersionWithoutChanges(t *testing.T) {
+	outDir := t.TempDir()
+	previous := Version
+	t.Cleanup(func() { Version = previous })
+	Version = "v1"
+
+	config, err := loadDefaultConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Provider = "aws"
+	config.ESC.Enabled = true
+
+	opts := GenerateOpts{
+		RepositoryName: "pulumi/pulumi-aws",
+		OutDir:         outDir,
+		TemplateName:   "bridged-provider",
+		Config:         config,
+		SkipMigrations: true,
+	}
+	if _, err := GeneratePackage(opts); err != nil {
+		t.Fatal(err)
+	}
+
+	// A newer ci-mgmt renders the same files.
+	Version = "v2"
+	changes, err := CheckPackage(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Fatalf("expected a new version alone not to change anything, got %#v", changes)
+	}
+
+	stale := filepath.Join(outDir, ".github", "workflows", "stale.yml")
+	if err := os.WriteFile(stale, []byte("stale\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	addToManifest(t, outDir, ".github/workflows/stale.yml", []byte("stale\n"))
+	if _, err := GeneratePackage(opts); err != nil {
+		t.Fatal(err)
+	}
+	m, err := readManifest(os.DirFS(outDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Version != "v2" {
+		t.Fatalf("expected the manifest to record the version which changed a file, got %q", m.Version)
+	}
+}
+
+// addToManifest records path in the manifest in outDir as if a previous
+// generation had produced it with the given content.
+func addToManifest(t *testing.T, outDir, path string, data []byte) {
+	t.Helper()
+	m, err := readManifest(os.DirFS(outDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Files[path] = hashContent(data)
+	out, err := m.marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(outDir, manifestFile), out, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGeneratePackageRendersToMemory(t *testing.T) {
+	config, err := loadDefaultConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Provider = "aws"
+	config.ESC.Enabled = true
+
+	fsys := NewMemFS()
+	if err := fsys.WriteFile(".github/workflows/stale.yml", []byte("stale\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := GenerateOpts{
+		RepositoryName: "pulumi/pulumi-aws",
+		TemplateName:   "bridged-provider",
+		Config:         config,
+		FS:             fsys,
+	}
+	if _, err := GeneratePackage(opts); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := fs.Stat(fsys, ".github/workflows/stale.yml"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected stale workflow to be removed, got err %v", err)
+	}
+	if _, err := fs.Stat(fsys, "Makefile"); err != nil {
+		t.Fatalf("expected Makefile to be rendered, got err %v", err)
+	}
+	script, err := fs.Stat(fsys, "scripts/upstream.sh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if script.Mode()&0o111 == 0 {
+		t.Fatalf("expected shell script to be executable, got mode %v", script.Mode())
+	}
+
+	changes, err := CheckPackage(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Fatalf("expected no changes after rendering to memory, got %#v", changes)
+	}
+}
+
+func TestGeneratePackageRendersOverlay(t *testing.T) {
+	config, err := loadDefaultConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Provider = "aws"
+	config.ESC.Enabled = true
+
+	fsys := NewMemFS()
+	overlay := map[string]string{
+		".ci-mgmt/overlay/Makefile":                    "# Makefile for #{{ .Config.Provider }}#\n",
+		".ci-mgmt/overlay/.github/workflows/extra.yml": "name: #{{ .ProjectName }}# extra\n",
+	}
+	for path, content := range overlay {
+		if err := fsys.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	opts := GenerateOpts{
+		RepositoryName: "pulumi/pulumi-aws",
+		TemplateName:   "bridged-provider",
+		Config:         config,
+		FS:             fsys,
+	}
+	gen, err := renderPackage(&opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []overlaidFile{
+		{path: ".github/workflows/extra.yml"},
+		{path: "Makefile", replaces: "base"},
+	}
+	if !reflect.DeepEqual(gen.overlaid, expected) {
+		t.Fatalf("expected overlaid files %#v, got %#v", expected, gen.overlaid)
+	}
+	if got := string(gen.files["Makefile"].data); got != "# Generated by ci-mgmt from template .ci-mgmt/overlay/Makefile\n# Makefile for aws\n" {
+		t.Fatalf("expected overlay to replace the Makefile, got:\n%s", got)
+	}
+	if got := string(gen.files[".github/workflows/extra.yml"].data); !strings.HasSuffix(got, "\nname: pulumi-aws extra\n") {
+		t.Fatalf("expected overlay to add a workflow, got:\n%s", got)
+	}
+}
+
+func TestGeneratePackageRendersProviderSplices(t *testing.T) {
+	config, err := loadDefaultConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Provider = "aws"
+	config.ESC.Enabled = true
+
+	fsys := NewMemFS()
+	splices := map[string]string{
+		".ci-mgmt/splices/testSteps.splice":    "- name: Extra test setup\n  run: make #{{ .Config.Provider }}#-setup\n",
+		".ci-mgmt/splices/releaseSteps.splice": "- name: Announce release\n  run: echo released\n",
+		".ci-mgmt/splices/env.splice":          "EXTRA_VAR: extra\n",
+	}
+	for path, content := range splices {
+		if err := fsys.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var stderr bytes.Buffer
+	if _, err := GeneratePackage(GenerateOpts{
+		RepositoryName: "pulumi/pulumi-aws",
+		TemplateName:   "bridged-provider",
+		Config:         config,
+		FS:             fsys,
+		Stderr:         &stderr,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(stderr.String(), "env.splice") {
+		t.Fatalf("expected the env splice to reach every workflow, got:\n%s", stderr.String())
+	}
+	workflows, err := fs.Glob(fsys, ".github/workflows/*.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range workflows {
+		data, err := fs.ReadFile(fsys, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var workflow struct {
+			Env map[string]string `yaml:"env"`
+		}
+		if err := yaml.Unmarshal(data, &workflow); err != nil {
+			t.Fatalf("expected valid YAML in %s, got %v", path, err)
+		}
+		if workflow.Env["EXTRA_VAR"] != "extra" {
+			t.Fatalf("expected env splice in %s, got %#v", path, workflow.Env)
+		}
+	}
+
+	var testWorkflow struct {
+		Env  map[string]string `yaml:"env"`
+		Jobs map[string]struct {
+			Steps []struct {
+				Name string `yaml:"name"`
+				Run  string `yaml:"run"`
+			} `yaml:"steps"`
+		} `yaml:"jobs"`
+	}
+	data, err := fs.ReadFile(fsys, ".github/workflows/test.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := yaml.Unmarshal(data, &testWorkflow); err != nil {
+		t.Fatalf("expected valid YAML, got %v:\n%s", err, data)
+	}
+	if testWorkflow.Env["EXTRA_VAR"] != "extra" {
+		t.Fatalf("expected env splice to be rendered, got %#v", testWorkflow.Env)
+	}
+	found := false
+	for _, step := range testWorkflow.Jobs["test"].Steps {
+		if step.Name == "Extra test setup" && step.Run == "make aws-setup" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("expected testSteps splice to be rendered, got:\n%s", data)
+	}
+
+	publish, err := fs.ReadFile(fsys, ".github/workflows/publish.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(publish), "    - name: Announce release\n      run: echo released\n") {
+		t.Fatalf("expected releaseSteps splice to be rendered, got:\n%s", publish)
+	}
+}
+
+func TestGeneratePackageWarnsAboutIgnoredEnvSplice(t *testing.T) {
+	config, err := loadDefaultConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Provider = "aws"
+	config.ESC.Enabled = true
+
+	fsys := NewMemFS()
+	for path, content := range map[string]string{
+		".ci-mgmt/splices/env.splice":                 "EXTRA_VAR: extra\n",
+		".ci-mgmt/overlay/.github/workflows/lint.yml": "name: lint\non: pull_request\njobs: {}\n",
+	} {
+		if err := fsys.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var stderr bytes.Buffer
+	if _, err := GeneratePackage(GenerateOpts{
+		RepositoryName: "pulumi/pulumi-aws",
+		TemplateName:   "bridged-provider",
+		Config:         config,
+		FS:             fsys,
+		Stdout:         io.Discard,
+		Stderr:         &stderr,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	expected := "warning: .ci-mgmt/splices/env.splice is not rendered into .github/workflows/lint.yml\n"
+	if stderr.String() != expected {
+		t.Fatalf("expected %q, got %q", expected, stderr.String())
+	}
+}
+
+func TestGeneratePackageRendersJobEnv(t *testing.T) {
+	config, err := loadDefaultConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Provider = "aws"
+	config.ESC.Enabled = true
+	config.JobEnv.Publish = map[string]EnvVar{"NPM_TOKEN": {Secret: "NPM_TOKEN"}}
+
+	fsys := NewMemFS()
+	if _, err := GeneratePackage(GenerateOpts{
+		RepositoryName: "pulumi/pulumi-aws",
+		TemplateName:   "bridged-provider",
+		Config:         config,
+		FS:             fsys,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var publishWorkflow struct {
+		Env  map[string]string `yaml:"env"`
+		Jobs map[string]struct {
+			Env map[string]string `yaml:"env"`
+		} `yaml:"jobs"`
+	}
+	data, err := fs.ReadFile(fsys, ".github/workflows/publish.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := yaml.Unmarshal(data, &publishWorkflow); err != nil {
+		t.Fatalf("expected valid YAML, got %v:\n%s", err, data)
+	}
+	for _, job := range []string{"publish", "publish_sdk"} {
+		if publishWorkflow.Jobs[job].Env["NPM_TOKEN"] != "${{ secrets.NPM_TOKEN }}" {
+			t.Fatalf("expected NPM_TOKEN in the %s job's env, got:\n%s", job, data)
+		}
+	}
+	if _, ok := publishWorkflow.Env["NPM_TOKEN"]; ok {
+		t.Fatal("expected NPM_TOKEN to be left out of the workflow's env")
+	}
+	if publishWorkflow.Jobs["create_docs_build"].Env != nil {
+		t.Fatalf("expected no env for other jobs, got %v", publishWorkflow.Jobs["create_docs_build"].Env)
+	}
+}
+
+func TestGeneratePackageRejectsUnknownSpliceSlots(t *testing.T) {
+	config, err := loadDefaultConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Provider = "aws"
+	config.ESC.Enabled = true
+
+	fsys := NewMemFS()
+	if err := fsys.WriteFile(".ci-mgmt/splices/lintSteps.splice", []byte("- run: echo\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = GeneratePackage(GenerateOpts{
+		RepositoryName: "pulumi/pulumi-aws",
+		TemplateName:   "bridged-provider",
+		Config:         config,
+		FS:             fsys,
+	})
+	if err == nil || !strings.Contains(err.Error(), "unknown splice slot(s) lintSteps") {
+		t.Fatalf("expected unknown slot error, got %v", err)
+	}
+}
+
+func TestGeneratePackageReportsResult(t *testing.T) {
+	config, err := loadDefaultConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Provider = "aws"
+	config.ESC.Enabled = true
+
+	fsys := NewMemFS()
+	opts := GenerateOpts{
+		RepositoryName: "pulumi/pulumi-aws",
+		TemplateName:   "bridged-provider",
+		Config:         config,
+		FS:             fsys,
+	}
+	result, err := GeneratePackage(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Files) == 0 || len(result.Changed()) != len(result.Files) {
+		t.Fatalf("expected every file to be created, got %+v", result.Files)
+	}
+
+	if err := fsys.WriteFile(".github/workflows/lint.yml", []byte("outdated\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	opts.Force = true
+	result, err = GeneratePackage(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []FileChange{{Path: ".github/workflows/lint.yml", Action: FileUpdated, Source: "all"}}
+	if !reflect.DeepEqual(result.Changed(), expected) {
+		t.Fatalf("expected %+v, got %+v", expected, result.Changed())
+	}
+	for _, f := range result.Files {
+		if f.Source == "" {
+			t.Fatalf("expected every file to have a source, got %+v", f)
+		}
+	}
+}
@@ -3,3 +3,54 @@ package pkg
 const (
 	defaultPulumiCTLVersion = "v0.0.46"
 )
+
+// ImplicitDefault is a config value which ApplyImplicitDefaults filled in.
+type ImplicitDefault struct {
+	// Path is the dotted path of the value, e.g. "toolVersions.pulumictl".
+	Path string
+	// Reason explains where the value came from.
+	Reason string
+}
+
+// ApplyImplicitDefaults fills in the fields of config which are still unset
+// once its layers are merged and which can't be defaulted in
+// defaults.config.yaml because they depend on the template and repository
+// being generated. It returns the defaults it applied.
+//
+// Every such default belongs here so `provider-ci config resolved` shows
+// exactly what the templates see.
+func ApplyImplicitDefaults(config *Config, templateName, repositoryName string) []ImplicitDefault {
+	var applied []ImplicitDefault
+
+	// GenName defaults to "tfgen" for bridged providers and "gen" for others
+	if config.GenName == "" {
+		config.GenName = DefaultGenName(templateName)
+		applied = append(applied, ImplicitDefault{"genName", "default for the " + templateName + " template"})
+	}
+
+	if config.ToolVersions.PulumiCTL == "" {
+		config.ToolVersions.PulumiCTL = defaultPulumiCTLVersion
+		applied = append(applied, ImplicitDefault{"toolVersions.pulumictl", "default pulumictl version"})
+	}
+
+	if config.TestFolder == "" {
+		config.TestFolder = "examples"
+		applied = append(applied, ImplicitDefault{"test-folder", "default test folder"})
+	}
+
+	if config.MaintenanceReleaseDay == 0 {
+		config.MaintenanceReleaseDay = 1
+		applied = append(applied, ImplicitDefault{"maintenanceReleaseDay", "default maintenance release day"})
+	}
+
+	// The boilerplate keeps its go.mod at the root, whatever the config says.
+	if repositoryName == "pulumi/pulumi-provider-boilerplate" {
+		config.ModulePath = "."
+		applied = append(applied, ImplicitDefault{"modulePath", "pulumi-provider-boilerplate keeps its module at the root"})
+	} else if config.ModulePath == "" {
+		config.ModulePath = "provider"
+		applied = append(applied, ImplicitDefault{"modulePath", "default module path"})
+	}
+
+	return applied
+}
@@ -0,0 +1,79 @@
+// Package deprecations lists the .ci-mgmt.yaml fields which providers should
+// stop setting. Config loading warns about them and the
+// removeDeprecatedConfig migration deletes them.
+package deprecations
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Field is a deprecated top-level .ci-mgmt.yaml field.
+type Field struct {
+	// Key is the field's name in .ci-mgmt.yaml.
+	Key string
+	// Reason explains why the field is deprecated.
+	Reason string
+	// Replacement is what to use instead, or "" if the field should simply be
+	// removed.
+	Replacement string
+	// RemoveAfter is the month after which the field will be rejected.
+	RemoveAfter string
+	// Templates limits the deprecation to these templates. The field is
+	// deprecated for every template if empty.
+	Templates []string
+}
+
+// Fields is the registry of deprecated fields.
+var Fields = []Field{
+	{
+		Key:         "parallel",
+		Reason:      "it has no effect outside native providers",
+		RemoveAfter: "2027-01",
+		Templates:   []string{"bridged-provider", "external-bridged-provider", "generic", "parameterized-go"},
+	},
+	{
+		Key:         "hybrid",
+		Reason:      "it has no effect",
+		RemoveAfter: "2027-01",
+	},
+	{
+		Key:         "team",
+		Reason:      "it has no effect",
+		RemoveAfter: "2027-01",
+	},
+	{
+		Key:         "makeTemplate",
+		Reason:      "it has no effect",
+		RemoveAfter: "2027-01",
+	},
+	{
+		Key:         "disableAgenticWorkflows",
+		Reason:      "agentic workflows are no longer generated",
+		RemoveAfter: "2027-01",
+	},
+}
+
+// For returns the fields which are deprecated for the given template.
+func For(templateName string) []Field {
+	var fields []Field
+	for _, f := range Fields {
+		if len(f.Templates) == 0 || slices.Contains(f.Templates, templateName) {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// Message describes the deprecation.
+func (f Field) Message() string {
+	return fmt.Sprintf("%q is deprecated and will be removed after %s: %s", f.Key, f.RemoveAfter, f.Reason)
+}
+
+// Suggestion describes how to stop using the field.
+func (f Field) Suggestion() string {
+	if f.Replacement != "" {
+		return fmt.Sprintf("use %s instead", f.Replacement)
+	}
+	return fmt.Sprintf("remove %q; running provider-ci generate removes it automatically", f.Key)
+}
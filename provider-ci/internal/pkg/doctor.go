@@ -0,0 +1,267 @@
+package pkg
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// goModFile is the part of a go.mod file the repository checks need.
+type goModFile struct {
+	Module string
+	// Requires are the module paths which are required or replaced.
+	Requires []string
+}
+
+// readGoMod reads the module path and requirements of a go.mod file.
+func readGoMod(path string) (goModFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return goModFile{}, fmt.Errorf("error reading %s: %w", path, err)
+	}
+	defer f.Close()
+
+	var mod goModFile
+	block := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "//")
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 0:
+		case block != "" && fields[0] == ")":
+			block = ""
+		case block != "":
+			mod.Requires = append(mod.Requires, fields[0])
+		case len(fields) == 2 && fields[1] == "(":
+			block = fields[0]
+		case fields[0] == "module" && len(fields) > 1:
+			mod.Module = strings.Trim(fields[1], `"`)
+		case (fields[0] == "require" || fields[0] == "replace") && len(fields) > 1:
+			mod.Requires = append(mod.Requires, fields[1])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return goModFile{}, fmt.Errorf("error reading %s: %w", path, err)
+	}
+	if mod.Module == "" {
+		return goModFile{}, fmt.Errorf("%s has no module directive", path)
+	}
+	return mod, nil
+}
+
+// provides reports whether the package at importPath belongs to the module
+// or one of its requirements.
+func (m goModFile) provides(importPath string) bool {
+	for _, module := range append([]string{m.Module}, m.Requires...) {
+		if importPath == module || strings.HasPrefix(importPath, module+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// DiagnoseConfig validates the .ci-mgmt.yaml at path with ValidateConfig and,
+// if it has no errors, cross-checks it against the repository in repoDir with
+// CheckRepository. If templateName or repositoryName are set, they are used
+// instead of the template and repository name the config gives, as generate
+// --template and --name do.
+func DiagnoseConfig(templates fs.FS, path, repoDir, templateName, repositoryName string) ([]Diagnostic, error) {
+	diags, err := validateConfig(templates, path, repoDir, templateName, repositoryName)
+	if err != nil || HasErrors(diags) {
+		return diags, err
+	}
+	repoDiags, err := checkRepository(templates, path, repoDir, templateName, repositoryName)
+	if err != nil {
+		return nil, err
+	}
+	return append(diags, repoDiags...), nil
+}
+
+// CheckRepository cross-checks the .ci-mgmt.yaml at path against the
+// repository in repoDir, reporting fields which contradict what the
+// repository contains: major-version and modulePath against go.mod, languages
+// against the sdk directories, and the paths named by sdkModuleDir,
+// test-folder, setup-script and providerVersion. Checks which need files the
+// repository doesn't have are skipped.
+//
+// The config is assumed to be valid (see ValidateConfig).
+func CheckRepository(templates fs.FS, path, repoDir string) ([]Diagnostic, error) {
+	return checkRepository(templates, path, repoDir, "", "")
+}
+
+// checkRepository is CheckRepository with the config's template and
+// repository name replaced by templateName and repositoryName, if set.
+func checkRepository(templates fs.FS, path, repoDir, templateName, repositoryName string) ([]Diagnostic, error) {
+	layers, err := loadConfigLayers(templates, path)
+	if err != nil {
+		return nil, err
+	}
+	merged, err := mergeConfigLayers(layers)
+	if err != nil {
+		return nil, err
+	}
+	config, err := loadActionVersionDefaults()
+	if err != nil {
+		return nil, err
+	}
+	if err := merged.decode(&config); err != nil {
+		return nil, fmt.Errorf("error parsing config file %s: %w", path, err)
+	}
+	if templateName != "" {
+		config.Template = templateName
+	}
+	if repositoryName == "" {
+		repositoryName = RepositoryName(config)
+	}
+	ApplyImplicitDefaults(&config, config.Template, repositoryName)
+
+	c := repoChecker{
+		validator: validator{path: path, repoDir: repoDir, layers: merged.layers},
+		root:      merged.root,
+		fileRoot:  layers[len(layers)-1].root,
+		config:    config,
+	}
+	if err := c.checkModule(); err != nil {
+		return nil, err
+	}
+	c.checkLanguages()
+	c.checkPaths()
+	return c.sorted(), nil
+}
+
+type repoChecker struct {
+	validator
+	// root is the merged config and fileRoot the top of the config file.
+	root, fileRoot *yaml.Node
+	config         Config
+}
+
+// nodeFor returns the node of the value at keys to report a problem with it.
+// Values which don't come from a file in the repository, such as defaults,
+// are reported at the top of the config file.
+func (c *repoChecker) nodeFor(keys ...string) *yaml.Node {
+	node := lookupNode(c.root, keys...)
+	if node == nil {
+		return c.fileRoot
+	}
+	if p, ok := c.layers[node]; ok && !isFileLayer(p) {
+		return c.fileRoot
+	}
+	return node
+}
+
+func (c *repoChecker) exists(rel string) bool {
+	_, err := os.Stat(filepath.Join(c.repoDir, filepath.FromSlash(rel)))
+	return err == nil
+}
+
+// checkModule checks modulePath, major-version and providerVersion against
+// the provider's go.mod.
+func (c *repoChecker) checkModule() error {
+	modulePath := c.config.ModulePath
+	goMod := filepath.Join(modulePath, "go.mod")
+	if !c.exists(goMod) {
+		found, foundPath, err := findGoMod(c.repoDir)
+		if err != nil {
+			return err
+		}
+		if found != "" {
+			c.errorAt(c.nodeFor("modulePath"), fmt.Sprintf("modulePath is %q but there is no %s", modulePath, filepath.ToSlash(goMod)),
+				fmt.Sprintf("set `modulePath: %s`", foundPath))
+		}
+		return nil
+	}
+
+	mod, err := readGoMod(filepath.Join(c.repoDir, goMod))
+	if err != nil {
+		return err
+	}
+
+	// Test fixtures and some repositories use a bare module name, such as
+	// pulumi-aws, which says nothing about the major version or packages.
+	first, _, _ := strings.Cut(mod.Module, "/")
+	if !strings.Contains(first, ".") {
+		return nil
+	}
+
+	if _, _, majorVersion := parseProviderModule(mod.Module); majorVersion > 0 {
+		configured := c.config.MajorVersion
+		switch {
+		case majorVersion >= 2 && configured != majorVersion:
+			c.errorAt(c.nodeFor("major-version"), fmt.Sprintf("major-version is %d but %s declares module %s", configured, filepath.ToSlash(goMod), mod.Module),
+				fmt.Sprintf("set `major-version: %d`", majorVersion))
+		case majorVersion < 2 && configured >= 2:
+			c.errorAt(c.nodeFor("major-version"), fmt.Sprintf("major-version is %d but module %s in %s has no /v%d suffix", configured, mod.Module, filepath.ToSlash(goMod), configured),
+				fmt.Sprintf("set `major-version` to 0 or 1, or add /v%d to the module path", configured))
+		}
+	}
+
+	if providerVersion := c.config.ProviderVersion; providerVersion != "" {
+		pkgPath := providerVersion
+		if i := strings.LastIndex(providerVersion, "."); i > strings.LastIndex(providerVersion, "/") {
+			pkgPath = providerVersion[:i]
+		}
+		if !mod.provides(pkgPath) {
+			c.warningAt(c.nodeFor("providerVersion"), fmt.Sprintf("providerVersion package %s is not in module %s or its requirements", pkgPath, mod.Module),
+				"point it at the variable which holds the upstream provider's version, e.g. github.com/org/terraform-provider-xyz/version.ProviderVersion")
+		}
+	}
+	return nil
+}
+
+// checkLanguages checks languages against the SDKs checked in under sdk.
+func (c *repoChecker) checkLanguages() {
+	if !c.exists("sdk") {
+		return
+	}
+	var present []string
+	for _, language := range supportedLanguages {
+		if c.exists(filepath.Join("sdk", language)) {
+			present = append(present, language)
+		}
+	}
+	if len(present) == 0 {
+		// The SDKs aren't checked in, so there is nothing to compare.
+		return
+	}
+	languages := c.nodeFor("languages")
+	for _, language := range c.config.Languages {
+		if !slices.Contains(present, language) {
+			c.warningAt(languages, fmt.Sprintf("languages includes %s but there is no sdk/%s", language, language),
+				fmt.Sprintf("remove %s from languages or generate its SDK", language))
+		}
+	}
+	for _, language := range present {
+		if !slices.Contains(c.config.Languages, language) {
+			c.warningAt(languages, fmt.Sprintf("sdk/%s exists but languages doesn't include %s", language, language),
+				fmt.Sprintf("add %s to languages or delete sdk/%s", language, language))
+		}
+	}
+
+	if dir := c.config.SDKModuleDir; dir != "" && slices.Contains(c.config.Languages, "go") && c.exists(filepath.Join("sdk", "go")) {
+		if !c.exists(filepath.Join(dir, "go.mod")) {
+			c.warningAt(c.nodeFor("sdkModuleDir"), fmt.Sprintf("sdkModuleDir is %q but there is no %s/go.mod", dir, dir),
+				"set sdkModuleDir to the directory containing the Go SDK's go.mod")
+		}
+	}
+}
+
+// checkPaths checks that files and directories the workflows use exist.
+func (c *repoChecker) checkPaths() {
+	// The default test folder isn't used by every template.
+	if folder := c.config.TestFolder; lookupNode(c.root, "test-folder") != nil && !c.exists(folder) {
+		c.warningAt(c.nodeFor("test-folder"), fmt.Sprintf("test-folder %q does not exist", folder),
+			"point it at the directory containing the integration tests")
+	}
+	if script := c.config.SetupScript; script != "" && !c.exists(script) {
+		c.errorAt(c.nodeFor("setup-script"), fmt.Sprintf("setup-script %q does not exist", script),
+			"add the script or remove setup-script")
+	}
+}
@@ -0,0 +1,142 @@
+package pkg
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckRepositoryReportsMismatches(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		".ci-mgmt.yaml": `provider: foo
+major-version: 2
+languages:
+  - nodejs
+  - go
+setup-script: testing/setup.sh
+providerVersion: github.com/acme/terraform-provider-foo/version.ProviderVersion
+esc:
+  enabled: true
+`,
+		"provider/go.mod": `module github.com/pulumi/pulumi-foo/provider/v3
+
+go 1.22
+
+require (
+	github.com/pulumi/pulumi-terraform-bridge/v3 v3.90.0 // indirect
+)
+`,
+		"sdk/nodejs/package.json": "{}",
+		"sdk/python/setup.py":     "",
+	})
+	configPath := filepath.Join(dir, ".ci-mgmt.yaml")
+
+	diags, err := DiagnoseConfig(embeddedTemplates, configPath, dir, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type problem struct {
+		line, column int
+		severity     Severity
+		message      string
+	}
+	expected := []problem{
+		{2, 16, SeverityError, "major-version is 2 but provider/go.mod declares module github.com/pulumi/pulumi-foo/provider/v3"},
+		{4, 3, SeverityWarning, "languages includes go but there is no sdk/go"},
+		{4, 3, SeverityWarning, "sdk/python exists but languages doesn't include python"},
+		{6, 15, SeverityError, `setup-script "testing/setup.sh" does not exist`},
+		{7, 18, SeverityWarning, "providerVersion package github.com/acme/terraform-provider-foo/version is not in module github.com/pulumi/pulumi-foo/provider/v3 or its requirements"},
+	}
+	if len(diags) != len(expected) {
+		t.Fatalf("expected %d diagnostics, got %d: %v", len(expected), len(diags), diags)
+	}
+	for i, e := range expected {
+		d := diags[i]
+		if d.Path != configPath || d.Line != e.line || d.Column != e.column || d.Severity != e.severity || d.Message != e.message {
+			t.Fatalf("diagnostic %d: expected %+v, got %+v", i, e, d)
+		}
+	}
+}
+
+func TestCheckRepositoryReportsDefaultsAtTopOfFile(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		".ci-mgmt.yaml": "provider: foo\nesc:\n  enabled: true\n",
+		"go.mod":        "module github.com/pulumi/pulumi-foo/v4\n\ngo 1.22\n",
+	})
+	configPath := filepath.Join(dir, ".ci-mgmt.yaml")
+
+	diags, err := CheckRepository(embeddedTemplates, configPath, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diags) != 1 || diags[0].Path != configPath || diags[0].Line != 1 || diags[0].Suggestion != "set `modulePath: .`" {
+		t.Fatalf("expected the default modulePath to be reported at the top of the file, got %v", diags)
+	}
+}
+
+func TestDiagnoseConfigSkipsRepositoryChecksForInvalidConfig(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		".ci-mgmt.yaml":   "provider: foo\nlnit: true\nmajor-version: 2\n",
+		"provider/go.mod": "module github.com/pulumi/pulumi-foo/provider/v3\n",
+	})
+
+	diags, err := DiagnoseConfig(embeddedTemplates, filepath.Join(dir, ".ci-mgmt.yaml"), dir, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range diags {
+		if d.Line == 3 {
+			t.Fatalf("expected repository checks to be skipped, got %v", diags)
+		}
+	}
+	if !HasErrors(diags) {
+		t.Fatalf("expected validation errors, got %v", diags)
+	}
+}
+
+func TestDiagnoseConfigChecksTheGivenTemplate(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		".ci-mgmt.yaml": "provider: foo\ntemplate: nativ\nesc:\n  enabled: true\n",
+	})
+	path := filepath.Join(dir, ".ci-mgmt.yaml")
+
+	diags, err := DiagnoseConfig(embeddedTemplates, path, dir, "native", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if HasErrors(diags) {
+		t.Fatalf("expected the template given to replace the config's, got %v", diags)
+	}
+
+	diags, err = DiagnoseConfig(embeddedTemplates, path, dir, "nope", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diags) != 1 || diags[0].Message != `unknown template "nope"` {
+		t.Fatalf("expected the given template to be checked, got %v", diags)
+	}
+}
+
+func TestDiagnoseConfigChecksTheGivenRepositoryName(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		".ci-mgmt.yaml": "provider: foo\n",
+	})
+	path := filepath.Join(dir, ".ci-mgmt.yaml")
+
+	// ESC is only required for Pulumi's own providers.
+	diags, err := DiagnoseConfig(embeddedTemplates, path, dir, "", "acme/pulumi-foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if HasErrors(diags) {
+		t.Fatalf("expected the repository name given to replace the config's, got %v", diags)
+	}
+
+	diags, err = DiagnoseConfig(embeddedTemplates, path, dir, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diags) != 1 || diags[0].Message != "ESC must be enabled for Pulumi providers" {
+		t.Fatalf("expected the config's repository name to be checked, got %v", diags)
+	}
+}
@@ -0,0 +1,185 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// FleetOpts configures GenerateFleet.
+type FleetOpts struct {
+	// Dir contains a checkout of each provider repository, named
+	// pulumi-<provider> as in providers.json.
+	Dir string
+	// Providers are the providers to generate, e.g.: aws
+	Providers []string
+	// Parallelism bounds how many providers are generated at once.
+	Parallelism int
+	// Templates contains the template directories. Defaults to the embedded
+	// templates (see LoadTemplateSource).
+	Templates fs.FS
+	// Force overwrites or deletes generated files even if they were modified
+	// by hand since the last generation.
+	Force bool
+}
+
+// FleetStatus is the outcome of generating one provider repository.
+type FleetStatus string
+
+const (
+	FleetChanged   FleetStatus = "changed"
+	FleetUnchanged FleetStatus = "unchanged"
+	FleetSkipped   FleetStatus = "skipped"
+	FleetFailed    FleetStatus = "failed"
+)
+
+// FleetResult reports the outcome of generating one provider repository.
+type FleetResult struct {
+	Provider string
+	Status   FleetStatus
+	// Changes are the files which were created, updated or deleted.
+	Changes []FileChange
+	// Reason explains why the provider was skipped.
+	Reason string
+	Err    error
+	// Log holds everything written while generating the provider.
+	Log []byte
+}
+
+// ReadProviderList reads a list of provider names such as providers.json.
+func ReadProviderList(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading provider list: %w", err)
+	}
+	var providers []string
+	if err := json.Unmarshal(data, &providers); err != nil {
+		return nil, fmt.Errorf("error parsing provider list %s: %w", path, err)
+	}
+	for i := range providers {
+		providers[i] = strings.TrimSpace(providers[i])
+	}
+	return providers, nil
+}
+
+// GenerateFleet generates every provider in opts.Providers from the
+// .ci-mgmt.yaml in its checkout, at most opts.Parallelism at a time. Results
+// are returned in the order of opts.Providers.
+//
+// Migrations are not run: they run tools in the provider's checkout and
+// assume it is the working directory.
+func GenerateFleet(opts FleetOpts) []FleetResult {
+	if opts.Templates == nil {
+		opts.Templates = embeddedTemplates
+	}
+	parallelism := opts.Parallelism
+	if parallelism < 1 {
+		parallelism = 1
+	}
+
+	results := make([]FleetResult, len(opts.Providers))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for range parallelism {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				results[i] = generateFleetProvider(opts, opts.Providers[i])
+			}
+		}()
+	}
+	for i := range opts.Providers {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	return results
+}
+
+func generateFleetProvider(opts FleetOpts, provider string) (result FleetResult) {
+	result.Provider = provider
+	var log bytes.Buffer
+	defer func() { result.Log = log.Bytes() }()
+
+	skip := func(reason string) FleetResult {
+		result.Status = FleetSkipped
+		result.Reason = reason
+		return result
+	}
+
+	repoDir := filepath.Join(opts.Dir, "pulumi-"+provider)
+	if _, err := os.Stat(repoDir); errors.Is(err, fs.ErrNotExist) {
+		return skip("no checkout at " + repoDir)
+	}
+	configPath := filepath.Join(repoDir, ".ci-mgmt.yaml")
+	if _, err := os.Stat(configPath); errors.Is(err, fs.ErrNotExist) {
+		return skip("no .ci-mgmt.yaml")
+	}
+
+	fail := func(err error) FleetResult {
+		result.Status = FleetFailed
+		result.Err = err
+		return result
+	}
+
+	// Deprecated fields are reported with the diagnostics below.
+	config, err := LoadLocalConfigFrom(opts.Templates, configPath, nil)
+	if err != nil {
+		// Report where the config is invalid if validation can tell.
+		if diags, diagErr := ValidateConfig(opts.Templates, configPath, repoDir); diagErr == nil && HasErrors(diags) {
+			for _, d := range diags {
+				fmt.Fprintln(&log, d)
+			}
+			return fail(fmt.Errorf("%s is invalid", configPath))
+		}
+		return fail(err)
+	}
+	repositoryName := RepositoryName(config)
+	if repositoryName == "" {
+		return fail(fmt.Errorf("repository name must be set in %s", configPath))
+	}
+	// Third-party providers are skipped before their config is checked, as
+	// generate does.
+	if !strings.HasPrefix(repositoryName, "pulumi/") {
+		return skip("third-party provider")
+	}
+
+	diags, err := DiagnoseConfig(opts.Templates, configPath, repoDir, "", repositoryName)
+	if err != nil {
+		return fail(err)
+	}
+	for _, d := range diags {
+		fmt.Fprintln(&log, d)
+	}
+	if HasErrors(diags) {
+		return fail(fmt.Errorf("%s is invalid", configPath))
+	}
+
+	generated, err := GeneratePackage(GenerateOpts{
+		RepositoryName: repositoryName,
+		OutDir:         repoDir,
+		Templates:      opts.Templates,
+		TemplateName:   config.Template,
+		Config:         config,
+		SkipMigrations: true,
+		Force:          opts.Force,
+		Stdout:         &log,
+		Stderr:         &log,
+	})
+	if err != nil {
+		return fail(err)
+	}
+	result.Changes = generated.Changed()
+	result.Status = FleetUnchanged
+	if len(result.Changes) > 0 {
+		result.Status = FleetChanged
+	}
+	return result
+}
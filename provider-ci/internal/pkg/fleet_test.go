@@ -0,0 +1,61 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGenerateFleet(t *testing.T) {
+	dir := t.TempDir()
+	writeConfig := func(provider, config string) {
+		t.Helper()
+		repoDir := filepath.Join(dir, "pulumi-"+provider)
+		if err := os.MkdirAll(repoDir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(repoDir, ".ci-mgmt.yaml"), []byte(config), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeConfig("aws", "provider: aws\nmajor-version: 6\nesc:\n  enabled: true\nenv:\n  AWS_SECRET: ${{ secrets.AWS_SECRET }}\n")
+	writeConfig("thirdparty", "provider: thirdparty\norganization: example\nmajor-version: 1\n")
+	writeConfig("broken", "provider: broken\nnot-a-field: true\n")
+	// Third-party providers are skipped even when their config is invalid.
+	writeConfig("brokenthirdparty", "provider: brokenthirdparty\norganization: example\nmajor-version: 1\nlanguages: [cobol]\n")
+
+	opts := FleetOpts{
+		Dir:         dir,
+		Providers:   []string{"aws", "thirdparty", "broken", "missing", "brokenthirdparty"},
+		Parallelism: 2,
+	}
+	results := GenerateFleet(opts)
+
+	expected := []FleetStatus{FleetChanged, FleetSkipped, FleetFailed, FleetSkipped, FleetSkipped}
+	for i, result := range results {
+		if result.Provider != opts.Providers[i] {
+			t.Fatalf("expected results in provider order, got %s at %d", result.Provider, i)
+		}
+		if result.Status != expected[i] {
+			t.Fatalf("expected %s to be %s, got %s (%v)", result.Provider, expected[i], result.Status, result.Err)
+		}
+	}
+	if len(results[0].Changes) == 0 {
+		t.Fatal("expected aws changes to be reported")
+	}
+	if !strings.Contains(string(results[0].Log), "warning: ESC is enabled") {
+		t.Fatalf("expected aws warnings to be captured in its log, got %q", results[0].Log)
+	}
+	if !strings.Contains(string(results[2].Log), `error: unknown field "not-a-field"`) {
+		t.Fatalf("expected config diagnostics for broken in its log, got %q", results[2].Log)
+	}
+	if results[4].Reason != "third-party provider" {
+		t.Fatalf("expected brokenthirdparty to be skipped as third-party, got %q", results[4].Reason)
+	}
+
+	results = GenerateFleet(opts)
+	if results[0].Status != FleetUnchanged {
+		t.Fatalf("expected aws to be unchanged on the second run, got %s: %v", results[0].Status, results[0].Changes)
+	}
+}
@@ -4,16 +4,18 @@ import (
 	"bytes"
 	"embed"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 	"text/template"
 
 	"github.com/Masterminds/sprig"
-	"github.com/pulumi/ci-mgmt/provider-ci/internal/pkg/contract"
 	"github.com/pulumi/ci-mgmt/provider-ci/internal/pkg/migrations"
 	"gopkg.in/yaml.v3"
 )
@@ -23,12 +25,29 @@ import (
 //go:embed all:templates
 var templateFS embed.FS
 
+// overlayDir is the directory within a provider repository containing
+// templates which are rendered on top of the embedded templates.
+const overlayDir = ".ci-mgmt/overlay"
+
 type GenerateOpts struct {
 	RepositoryName string // e.g.: pulumi/pulumi-aws
 	OutDir         string
+	// FS is where existing files are read from and generated files are
+	// written to. Defaults to the directory OutDir on disk. Migrations modify
+	// files on disk directly so they only run when FS is backed by the OS.
+	FS WritableFS
+	// Templates contains the template directories, laid out as the embedded
+	// templates directory. Defaults to the embedded templates.
+	Templates      fs.FS
 	TemplateName   string // path inside templates, e.g.: bridged-provider
 	Config         Config // .yaml file containing template config
 	SkipMigrations bool
+	// Force overwrites or deletes generated files even if they were modified
+	// by hand since the last generation.
+	Force bool
+	// Stdout and Stderr receive progress messages and warnings respectively.
+	// They default to os.Stdout and os.Stderr.
+	Stdout, Stderr io.Writer
 }
 
 // Data exposed to text/template that can be referenced in the template code.
@@ -40,6 +59,9 @@ type templateContext struct {
 	// Adding a foo.splice file will generate a "foo" entry in this map with the value being the
 	// result of rendering foo.splice template.
 	Splices map[string]string
+
+	// stderr receives warnings emitted by template functions.
+	stderr io.Writer
 }
 
 // DefaultGenName returns the default codegen name for a template: "gen" for
@@ -51,64 +73,142 @@ func DefaultGenName(templateName string) string {
 	return "tfgen"
 }
 
-func GeneratePackage(opts GenerateOpts) error {
-	templateDirs, err := getTemplateDirs(opts.TemplateName)
-	if err != nil {
-		return fmt.Errorf("error getting template directories: %w", err)
+// RepositoryName returns the repository configured by config: its
+// "repository" field or otherwise "{organization}/pulumi-{provider}". It
+// returns "" if neither is set.
+func RepositoryName(config Config) string {
+	if config.Repository != "" {
+		return config.Repository
+	}
+	if config.Provider != "" && config.Organization != "" {
+		return fmt.Sprintf("%s/pulumi-%s", config.Organization, config.Provider)
 	}
+	return ""
+}
 
-	// GenName defaults to "tfgen" for bridged providers and "gen" for others
-	if opts.Config.GenName == "" {
-		opts.Config.GenName = DefaultGenName(opts.TemplateName)
+// GeneratePackage renders the package into opts.FS, runs any relevant
+// migrations and reports what happened to each file.
+func GeneratePackage(opts GenerateOpts) (GenerateResult, error) {
+	gen, err := renderPackage(&opts)
+	if err != nil {
+		return GenerateResult{}, err
+	}
+	files, err := gen.diff(opts.FS)
+	if err != nil {
+		return GenerateResult{}, err
+	}
+	if err := gen.write(opts.FS); err != nil {
+		return GenerateResult{}, err
+	}
+	for _, f := range gen.overlaid {
+		if f.replaces == "" {
+			fmt.Fprintf(opts.Stdout, "Overlay: %s: added\n", f.path)
+		} else {
+			fmt.Fprintf(opts.Stdout, "Overlay: %s: replaces %s\n", f.path, f.replaces)
+		}
+	}
+	if osfs, ok := opts.FS.(osFS); ok && !opts.SkipMigrations {
+		// Run any relevant migrations
+		migrated, err := migrations.Migrate(opts.TemplateName, osfs.dir, opts.Stdout)
+		if err != nil {
+			return GenerateResult{}, fmt.Errorf("error running migrations: %w", err)
+		}
+		for _, change := range migrated {
+			files = append(files, FileChange{Path: change.Path, Action: FileAction(change.Action), Source: change.Migration})
+		}
+		sort.SliceStable(files, func(i, j int) bool { return files[i].Path < files[j].Path })
 	}
 
-	if opts.Config.ToolVersions.PulumiCTL == "" {
-		opts.Config.ToolVersions.PulumiCTL = defaultPulumiCTLVersion
+	return GenerateResult{Files: files}, nil
+}
+
+// CheckPackage renders the package in memory and reports how opts.FS
+// differs from it. Nothing is written and migrations are not run. Files which
+// were modified by hand are reported as they would be with opts.Force.
+func CheckPackage(opts GenerateOpts) ([]FileChange, error) {
+	opts.Force = true
+	gen, err := renderPackage(&opts)
+	if err != nil {
+		return nil, err
 	}
+	return gen.changes(opts.FS)
+}
 
-	if opts.Config.TestFolder == "" {
-		opts.Config.TestFolder = "examples"
+// renderPackage renders every template directory and computes the files to be
+// deleted without modifying opts.FS. Defaults are applied to opts.Config and
+// opts.FS.
+func renderPackage(opts *GenerateOpts) (*generation, error) {
+	if opts.FS == nil {
+		opts.FS = NewOSFS(opts.OutDir)
+	}
+	if opts.Templates == nil {
+		opts.Templates = embeddedTemplates
+	}
+	if opts.Stdout == nil {
+		opts.Stdout = os.Stdout
+	}
+	if opts.Stderr == nil {
+		opts.Stderr = os.Stderr
 	}
 
-	if opts.Config.MaintenanceReleaseDay == 0 {
-		opts.Config.MaintenanceReleaseDay = 1
+	templateDirs, err := getTemplateDirs(opts.TemplateName)
+	if err != nil {
+		return nil, fmt.Errorf("error getting template directories: %w", err)
 	}
 
+	ApplyImplicitDefaults(&opts.Config, opts.TemplateName, opts.RepositoryName)
+
+	gen := &generation{files: map[string]generatedFile{}, version: ReadBuildInfo().Version}
+
 	// Clean up old workflows if requested
 	if opts.Config.CleanGithubWorkflows {
-		err := cleanGithubWorkflows(opts.OutDir, opts.Config.Provider)
+		workflows, err := staleGithubWorkflows(opts.FS, opts.Config.Provider)
 		if err != nil {
-			return err
+			return nil, err
+		}
+		for _, workflow := range workflows {
+			gen.delete(workflow, sourceCleanGithubWorkflows)
 		}
 	}
 	// Clean up files which are marked for deletion
 	for _, deletedFile := range getDeletedFiles(opts.TemplateName) {
-		err = os.RemoveAll(filepath.Join(opts.OutDir, deletedFile))
-		if err != nil {
-			return fmt.Errorf("error deleting file %s: %w", deletedFile, err)
-		}
+		gen.delete(deletedFile, sourceRetired)
+	}
+
+	// Check the layers compose cleanly before rendering anything.
+	if _, err := composeTemplateDirs(opts.Templates, ".", templateDirs, templateOverrides); err != nil {
+		return nil, err
+	}
+
+	providerSplices, err := collectProviderSplices(opts.FS, newTemplateContext(*opts))
+	if err != nil {
+		return nil, err
 	}
+
 	for _, templateDir := range templateDirs {
-		err = renderTemplateDir(templateDir, opts)
+		err = renderTemplateDir(templateDir, *opts, providerSplices, gen)
 		if err != nil {
-			return fmt.Errorf("error rendering template %s: %w", templateDir, err)
+			return nil, fmt.Errorf("error rendering template %s: %w", templateDir, err)
 		}
 	}
+	// The repository's own overlay is rendered last so it can replace anything.
+	if err := renderOverlay(*opts, providerSplices, gen); err != nil {
+		return nil, fmt.Errorf("error rendering %s: %w", overlayDir, err)
+	}
+	// These are removed even if a template rendered them.
 	for _, deletedFile := range getConfigDeletedFiles(opts.Config) {
-		err = os.RemoveAll(filepath.Join(opts.OutDir, deletedFile))
-		if err != nil {
-			return fmt.Errorf("error deleting file %s: %w", deletedFile, err)
-		}
+		delete(gen.files, filepath.ToSlash(deletedFile))
+		gen.delete(deletedFile, sourceRetired)
 	}
-	if !opts.SkipMigrations {
-		// Run any relevant migrations
-		err = migrations.Migrate(opts.TemplateName, opts.OutDir)
-		if err != nil {
-			return fmt.Errorf("error running migrations: %w", err)
-		}
+	if providerSplices["env"] != "" {
+		warnIgnoredEnvSplice(gen, opts.Stderr)
 	}
 
-	return nil
+	if err := reconcileManifest(gen, opts.FS, opts.Force, opts.Stderr); err != nil {
+		return nil, err
+	}
+
+	return gen, nil
 }
 
 // workflowCleanAllowList contains workflow directory entries that should never
@@ -117,16 +217,19 @@ var workflowCleanAllowList = map[string]bool{
 	"shared": true,
 }
 
-func cleanGithubWorkflows(outDir string, providerName string) error {
-	workflows, err := os.ReadDir(filepath.Join(outDir, ".github", "workflows"))
+// staleGithubWorkflows lists the existing entries within .github/workflows which
+// are not provider-specific and should therefore be replaced by the templates.
+func staleGithubWorkflows(fsys fs.FS, providerName string) ([]string, error) {
+	workflows, err := fs.ReadDir(fsys, ".github/workflows")
 	if err != nil {
-		if os.IsNotExist(err) {
+		if errors.Is(err, fs.ErrNotExist) {
 			// No workflows to clean up
-			return nil
+			return nil, nil
 		}
-		return fmt.Errorf("error reading .github/workflows directory: %w", err)
+		return nil, fmt.Errorf("error reading .github/workflows directory: %w", err)
 	}
 
+	var stale []string
 	for _, workflow := range workflows {
 		// Skip provider-specific workflows which are prefixed with the provider name
 		if strings.HasPrefix(workflow.Name(), providerName+"-") {
@@ -136,12 +239,9 @@ func cleanGithubWorkflows(outDir string, providerName string) error {
 		if workflowCleanAllowList[workflow.Name()] {
 			continue
 		}
-		err = os.Remove(filepath.Join(outDir, ".github", "workflows", workflow.Name()))
-		if err != nil {
-			return fmt.Errorf("error deleting workflow %s: %w", workflow.Name(), err)
-		}
+		stale = append(stale, path.Join(".github", "workflows", workflow.Name()))
 	}
-	return nil
+	return stale, nil
 }
 
 func getDeletedFiles(templateName string) []string {
@@ -211,43 +311,79 @@ func getConfigDeletedFiles(config Config) []string {
 	return deletedFiles
 }
 
-func renderTemplateDir(template TemplateDir, opts GenerateOpts) error {
-	// Template context is global and loaded from the file at opts.configPath
-	// The embedded filesystem templateFS should contain a subdirectory with the name of opts.templateName
-	// For each file in the subdirectory, apply templating and write to opts.outDir with the same relative path
-	// e.g.: bridged-provider/foo/bar.yaml -> $outDir/foo/bar.yaml
+func renderTemplateDir(template TemplateDir, opts GenerateOpts, providerSplices map[string]string, gen *generation) error {
+	if !HasTemplate(opts.Templates, template) {
+		return fmt.Errorf("template %s not found", template)
+	}
+	return renderDir(opts.Templates, string(template), string(template), opts, providerSplices, gen)
+}
 
-	// Templates have access to .name and .config contexts.
-	// .name is opts.packageName
-	// .config is the unmarshalled YAML content of opts.configPath
+// renderOverlay renders the repository's own overlay directory, if it has one,
+// on top of the embedded templates. Overlay files may add new files or replace
+// generated ones.
+func renderOverlay(opts GenerateOpts, providerSplices map[string]string, gen *generation) error {
+	info, err := fs.Stat(opts.FS, overlayDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading %s: %w", overlayDir, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s must be a directory", overlayDir)
+	}
 
-	if !HasTemplate(template) {
-		return fmt.Errorf("template %s not found", template)
+	layers := map[string]string{}
+	for p, f := range gen.files {
+		layers[p] = f.layer
+	}
+	if err := renderDir(opts.FS, overlayDir, overlayDir, opts, providerSplices, gen); err != nil {
+		return err
 	}
+	for _, p := range gen.paths() {
+		if gen.files[p].layer != overlayDir {
+			continue
+		}
+		gen.overlaid = append(gen.overlaid, overlaidFile{path: p, replaces: layers[p]})
+	}
+	return nil
+}
 
-	config := opts.Config
+// newTemplateContext returns the context templates are rendered with, before
+// any splices are collected.
+func newTemplateContext(opts GenerateOpts) templateContext {
+	// Templates have access to .name and .config contexts.
+	// .name is opts.packageName
+	// .config is the unmarshalled YAML content of opts.configPath
 
 	projName := strings.TrimPrefix(opts.RepositoryName, "pulumi/")
 
-	if projName == "pulumi-provider-boilerplate" {
-		config.ModulePath = "."
-	}
-
-	ctx := templateContext{
+	return templateContext{
 		Repository:  opts.RepositoryName,
 		ProjectName: projName,
-		Config:      config,
+		Config:      opts.Config,
+		stderr:      opts.Stderr,
 	}
+}
 
-	templateDir := filepath.Join("templates", string(template))
+// renderDir renders every template file within dir of fsys into gen, with
+// layer recorded as the source of each file. Splices defined in dir are
+// extended with providerSplices.
+func renderDir(fsys fs.FS, dir, layer string, opts GenerateOpts, providerSplices map[string]string, gen *generation) error {
+	// Template context is global and loaded from the file at opts.configPath
+	// For each file in dir, apply templating and write to opts.outDir with the same relative path
+	// e.g.: templates/bridged/foo/bar.yaml -> $outDir/foo/bar.yaml
+	ctx := newTemplateContext(opts)
+	config := opts.Config
 
 	var err error
-	ctx.Splices, err = collectSplices(templateDir, ctx)
+	ctx.Splices, err = collectSplices(fsys, dir, ctx)
 	if err != nil {
 		return err
 	}
+	mergeSplices(ctx.Splices, providerSplices)
 
-	err = fs.WalkDir(templateFS, templateDir, func(path string, d fs.DirEntry, err error) error {
+	err = fs.WalkDir(fsys, dir, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
@@ -258,22 +394,18 @@ func renderTemplateDir(template TemplateDir, opts GenerateOpts) error {
 			return nil
 		}
 		inPath := path
-		outPath, err := filepath.Rel(templateDir, path)
-		if err != nil {
-			return err
-		}
-		outPath = filepath.Join(opts.OutDir, outPath)
+		outPath := strings.TrimPrefix(path, dir+"/")
 		// Sub in the correct Workflow name by repo default branch
 		if strings.Contains(inPath, "main.yml") {
 			branchName := config.ProviderDefaultBranch
 			outPath = strings.ReplaceAll(outPath, "main", branchName)
 		}
-		tmpl, err := parseTemplate(templateFS, inPath)
+		tmpl, err := parseTemplate(fsys, inPath, ctx.stderr)
 		if err != nil {
 			return fmt.Errorf("error parsing template %s: %w", inPath, err)
 		}
 
-		err = renderTemplateFile(tmpl, outPath, ctx)
+		err = renderTemplateFile(tmpl, layer, outPath, ctx, gen)
 		if err != nil {
 			return fmt.Errorf("error rendering template %s: %w", inPath, err)
 		}
@@ -286,9 +418,9 @@ func renderTemplateDir(template TemplateDir, opts GenerateOpts) error {
 	return nil
 }
 
-func collectSplices(templateDir string, tc templateContext) (map[string]string, error) {
+func collectSplices(fsys fs.FS, templateDir string, tc templateContext) (map[string]string, error) {
 	splices := map[string]string{}
-	err := fs.WalkDir(templateFS, templateDir, func(path string, d fs.DirEntry, err error) error {
+	err := fs.WalkDir(fsys, templateDir, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
@@ -298,7 +430,7 @@ func collectSplices(templateDir string, tc templateContext) (map[string]string,
 		if !strings.HasSuffix(path, ".splice") {
 			return nil
 		}
-		tmpl, err := parseTemplate(templateFS, path)
+		tmpl, err := parseTemplate(fsys, path, tc.stderr)
 		if err != nil {
 			return fmt.Errorf("error parsing template %s: %w", path, err)
 		}
@@ -315,8 +447,9 @@ func collectSplices(templateDir string, tc templateContext) (map[string]string,
 	return splices, nil
 }
 
-func ListTemplates() ([]TemplateDir, error) {
-	dirEntries, err := templateFS.ReadDir("templates")
+// ListTemplates lists the template directories in templates.
+func ListTemplates(templates fs.FS) ([]TemplateDir, error) {
+	dirEntries, err := fs.ReadDir(templates, ".")
 	if err != nil {
 		return nil, err
 	}
@@ -329,20 +462,20 @@ func ListTemplates() ([]TemplateDir, error) {
 	return templateNames, nil
 }
 
-func HasTemplate(name TemplateDir) bool {
-	templates, err := ListTemplates()
+func HasTemplate(templates fs.FS, name TemplateDir) bool {
+	dirs, err := ListTemplates(templates)
 	if err != nil {
 		return false
 	}
-	for _, template := range templates {
-		if template == name {
+	for _, dir := range dirs {
+		if dir == name {
 			return true
 		}
 	}
 	return false
 }
 
-func renderTemplateFile(tmpl *template.Template, outPath string, ctx templateContext) error {
+func renderTemplateFile(tmpl *template.Template, layer string, outPath string, ctx templateContext, gen *generation) error {
 	var outData bytes.Buffer
 	err := tmpl.Execute(&outData, ctx)
 	if err != nil {
@@ -353,33 +486,20 @@ func renderTemplateFile(tmpl *template.Template, outPath string, ctx templateCon
 		return nil
 	}
 
-	err = os.MkdirAll(filepath.Dir(outPath), 0o755)
-	if err != nil {
-		return err
-	}
-
-	outFile, err := os.Create(outPath)
-	if err != nil {
-		return err
-	}
-	defer contract.IgnoreError(outFile.Close)
-
-	_, err = io.Copy(outFile, &outData)
-	if err != nil {
-		return err
-	}
-
+	mode := fs.FileMode(0o644)
 	// Make shell scripts executable
 	if strings.HasSuffix(outPath, ".sh") {
-		err = os.Chmod(outPath, 0o755)
-		if err != nil {
-			return err
-		}
+		mode = 0o755
 	}
+	data := addProvenanceHeader(outPath, tmpl.Name(), outData.Bytes())
+	envSplice := strings.Contains(tmpl.Root.String(), ".Splices.env")
+	gen.files[outPath] = generatedFile{data: data, mode: mode, layer: layer, envSplice: envSplice}
 	return nil
 }
 
-func parseTemplate(fsys fs.FS, inPath string) (*template.Template, error) {
+// parseTemplate parses the template at inPath. Warnings emitted by template
+// functions while it is executed are written to stderr.
+func parseTemplate(fsys fs.FS, inPath string, stderr io.Writer) (*template.Template, error) {
 	inData, err := fs.ReadFile(fsys, inPath)
 	if err != nil {
 		return nil, err
@@ -389,9 +509,14 @@ func parseTemplate(fsys fs.FS, inPath string) (*template.Template, error) {
 		"toYaml":                    toYAML,
 		"renderEscStep":             renderESCStep,
 		"renderGlobalEnv":           renderGlobalEnv,
-		"renderLocalEnv":            renderLocalEnv,
+		"renderConcurrency":         renderConcurrency,
+		"renderJobEnv":              renderJobEnv,
+		"renderLocalEnv":            func(v any) (string, error) { return renderLocalEnv(v, stderr) },
 		"renderOpenInspectSettings": renderOpenInspectSettings,
+		"renderPathFilterOutputs":   renderPathFilterOutputs,
+		"renderPathFilters":         renderPathFilters,
 		"renderPublishEnv":          renderPublishEnv,
+		"timeoutMinutes":            timeoutMinutes,
 	}).Funcs(sprig.FuncMap()).Delims("#{{", "}}#").Parse(string(inData))
 	if err != nil {
 		return nil, err
@@ -497,10 +622,13 @@ func renderGlobalEnv(v any) (string, error) {
 	}
 
 	for k, v := range config.Env {
-		if config.ESC.Enabled && strings.Contains(v, "secrets.") {
+		if v.scope() != EnvScopeAll {
+			continue // Scoped to other jobs; see renderJobEnv.
+		}
+		if config.ESC.Enabled && v.isSecret() {
 			continue // Omit secrets from the global env.
 		}
-		env[k] = v
+		env[k] = v.expression()
 	}
 
 	// Enable PULUMI_PULUMI_ENABLE_JOURNALING=true globally for all workflows.
@@ -549,24 +677,150 @@ func renderPublishEnv(v any) (string, error) {
 	}
 
 	for k, v := range config.Env {
-		if config.ESC.Enabled && strings.Contains(v, "secrets.") {
+		if v.scope() != EnvScopeAll {
+			continue // Scoped to other jobs; see renderJobEnv.
+		}
+		if config.ESC.Enabled && v.isSecret() {
 			continue // Omit secrets from the global env.
 		}
-		env[k] = v
+		env[k] = v.expression()
+	}
+
+	return toYAML(env)
+}
+
+// renderJobEnv is used to generate the environment variables scoped to one
+// kind of job, e.g. "publish", from jobEnv and env entries with that scope.
+// These are rendered on the job rather than the workflow so other jobs in the
+// workflow don't see them. It returns an empty string if there are none.
+//
+// Entries scoped to tests are rendered on test steps by renderLocalEnv
+// instead, since they may read ESC outputs.
+func renderJobEnv(scope string, v any) (string, error) {
+	config, ok := v.(Config)
+	if !ok {
+		return "", fmt.Errorf("expected Config input, got %+v", v)
+	}
+	switch EnvScope(scope) {
+	case EnvScopePrerequisites, EnvScopeBuildSDK, EnvScopePublish, EnvScopeUpgradeProvider:
+	default:
+		return "", fmt.Errorf("renderJobEnv: unsupported scope %q", scope)
 	}
 
+	env := map[string]string{}
+	for k, v := range config.scopedEnv(EnvScope(scope)) {
+		env[k] = v.expression()
+	}
+	if len(env) == 0 {
+		return "", nil
+	}
 	return toYAML(env)
 }
 
+// renderConcurrency renders the body of the concurrency of workflows of the
+// given kind, or an empty string if they have none.
+func renderConcurrency(kind string, v any) (string, error) {
+	config, ok := v.(Config)
+	if !ok {
+		return "", fmt.Errorf("expected Config input, got %+v", v)
+	}
+	c, err := config.Concurrency.forKind(kind)
+	if err != nil || c == nil {
+		return "", err
+	}
+	type workflowConcurrency struct {
+		Group            string `yaml:"group"`
+		CancelInProgress bool   `yaml:"cancel-in-progress"`
+	}
+	return toYAML(workflowConcurrency{c.Group, c.CancelInProgress})
+}
+
+// renderPathFilters renders the filters input of dorny/paths-filter for each
+// filtered kind of job. It expects the "every" predicate quantifier, so a
+// file matches a filter when it matches the includes, combined into one glob,
+// and none of the excludes.
+func renderPathFilters(v any) (string, error) {
+	config, ok := v.(Config)
+	if !ok {
+		return "", fmt.Errorf("expected Config input, got %+v", v)
+	}
+	var b strings.Builder
+	kinds, filters := config.PathFilters.kinds()
+	for i, filter := range filters {
+		if filter == nil {
+			continue
+		}
+		include := "**"
+		switch len(filter.Include) {
+		case 0:
+		case 1:
+			include = filter.Include[0]
+		default:
+			include = "{" + strings.Join(filter.Include, ",") + "}"
+		}
+		globs := []string{include}
+		for _, exclude := range filter.Exclude {
+			globs = append(globs, "!"+exclude)
+		}
+		rendered, err := toYAML(map[string][]string{kinds[i]: globs})
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(rendered + "\n")
+	}
+	return strings.TrimSuffix(b.String(), "\n"), nil
+}
+
+// renderPathFilterOutputs renders the outputs of the job which runs
+// dorny/paths-filter: whether each kind of job should run. A kind runs if its
+// files or those of a kind which depends on it changed, if it isn't filtered,
+// or if the workflow didn't run for a pull request.
+func renderPathFilterOutputs(v any) (string, error) {
+	config, ok := v.(Config)
+	if !ok {
+		return "", fmt.Errorf("expected Config input, got %+v", v)
+	}
+	outputs := map[string]string{}
+	kinds, filters := config.PathFilters.kinds()
+	for i, kind := range kinds {
+		outputs[kind] = "true"
+		conditions := []string{"github.event_name != 'pull_request'"}
+		for j := i; j < len(kinds); j++ {
+			if filters[j] == nil {
+				conditions = nil
+				break
+			}
+			conditions = append(conditions, fmt.Sprintf("steps.filter.outputs.%s == 'true'", kinds[j]))
+		}
+		if conditions != nil {
+			outputs[kind] = "${{ " + strings.Join(conditions, " || ") + " }}"
+		}
+	}
+	return toYAML(outputs)
+}
+
+// timeoutMinutes returns the timeout-minutes of jobs of the given kind:
+// prerequisites, buildSdk, test, publish or default for any other job. It
+// returns 0 if they have no timeout, so templates can omit it.
+func timeoutMinutes(kind string, v any) (int, error) {
+	config, ok := v.(Config)
+	if !ok {
+		return 0, fmt.Errorf("expected Config input, got %+v", v)
+	}
+	return config.timeoutMinutes(kind)
+}
+
 // renderLocalEnv is responsible for generating more targeted environment variables for use in e.g. test steps.
 
-// If ESC is enabled, environment variables from ci-mgmt.yml are rendered with secrets replaced by
-// ESC outputs. Plaintext values are omitted since they are already contained in the global environment.
+// If ESC is enabled, secrets from ci-mgmt.yml are rendered here rather than in the global environment.
+// Secrets written as a "${{ secrets.X }}" string are replaced by ESC outputs. Plaintext values are
+// omitted since they are already contained in the global environment.
 //
-// If ESC is disabled this only passes GITHUB_TOKEN to the step.
+// If ESC is disabled this passes GITHUB_TOKEN to the step. In either case, entries scoped to tests,
+// from env or jobEnv, are only rendered here.
 //
 // Refs https://github.com/pulumi/ci-mgmt/issues/1481.
-func renderLocalEnv(v any) (string, error) {
+func renderLocalEnv(v any, stderr io.Writer) (string, error) {
 	config, ok := v.(Config)
 	if !ok {
 		return "", fmt.Errorf("expected Config input, got %+v", v)
@@ -576,20 +830,23 @@ func renderLocalEnv(v any) (string, error) {
 		"GITHUB_TOKEN": "${{ secrets.GITHUB_TOKEN }}",
 	}
 
-	if !config.ESC.Enabled {
-		return toYAML(env)
+	if config.ESC.Enabled {
+		for k, v := range config.Env {
+			if v.scope() != EnvScopeAll || !v.isSecret() {
+				continue // Plaintext values are already in the global env.
+			}
+			value := v.expression()
+			if v.untyped && k != "GITHUB_TOKEN" && (strings.HasPrefix(value, "${{secrets.") || strings.HasPrefix(value, "${{ secrets.")) {
+				fixed := strings.Replace(value, "secrets.", "steps.esc-secrets.outputs.", 1)
+				fmt.Fprintf(stderr, "warning: ESC is enabled, correcting '%s: %s' to be '%s: %s'; declare it with `esc:` or `secret:` instead\n", k, value, k, fixed)
+				value = fixed
+			}
+			env[k] = value
+		}
 	}
 
-	for k, v := range config.Env {
-		if !strings.Contains(v, "secrets.") {
-			continue // Omit plaintext values already in the global env.
-		}
-		if k != "GITHUB_TOKEN" && (strings.HasPrefix(v, "${{secrets.") || strings.HasPrefix(v, "${{ secrets.")) {
-			fixed := strings.Replace(v, "secrets.", "steps.esc-secrets.outputs.", 1)
-			fmt.Fprintf(os.Stderr, "warning: ESC is enabled, correcting '%s: %s' to be '%s: %s'\n", k, v, k, fixed)
-			v = fixed
-		}
-		env[k] = v
+	for k, v := range config.scopedEnv(EnvScopeTest) {
+		env[k] = v.expression()
 	}
 
 	return toYAML(env)
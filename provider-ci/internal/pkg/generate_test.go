@@ -0,0 +1,131 @@
+package pkg
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestRenderEnvFollowsDeclaredKindsAndScopes(t *testing.T) {
+	var config Config
+	if err := yaml.Unmarshal([]byte(`organization: pulumi
+esc:
+  enabled: true
+env:
+  REGION: us-west-2
+  LEGACY_SECRET: ${{ secrets.LEGACY_SECRET }}
+  NOT_A_SECRET:
+    value: see secrets.md
+  TEST_ONLY:
+    value: "true"
+    scope: test
+  ARM_CLIENT_SECRET:
+    esc: ARM_CLIENT_SECRET
+  GITHUB_SECRET:
+    secret: SOME_SECRET
+  OSSRH_PASSWORD:
+    secret: OSSRH_PASSWORD
+    scope: publish
+jobEnv:
+  test:
+    TEST_BLOCK:
+      esc: TEST_BLOCK
+  publish:
+    NPM_TOKEN:
+      secret: NPM_TOKEN
+  prerequisites:
+    REGION: us-east-1
+`), &config); err != nil {
+		t.Fatal(err)
+	}
+
+	render := func(f func(any) (string, error)) map[string]string {
+		t.Helper()
+		out, err := f(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var env map[string]string
+		if err := yaml.Unmarshal([]byte(out), &env); err != nil {
+			t.Fatal(err)
+		}
+		return env
+	}
+	var stderr bytes.Buffer
+	renderLocal := func(v any) (string, error) { return renderLocalEnv(v, &stderr) }
+
+	for _, c := range []struct {
+		name     string
+		env      map[string]string
+		expected map[string]string
+		absent   []string
+	}{
+		{
+			name: "global",
+			env:  render(renderGlobalEnv),
+			expected: map[string]string{
+				"REGION":       "us-west-2",
+				"NOT_A_SECRET": "see secrets.md",
+			},
+			absent: []string{"LEGACY_SECRET", "TEST_ONLY", "ARM_CLIENT_SECRET", "GITHUB_SECRET", "OSSRH_PASSWORD"},
+		},
+		{
+			name: "publish",
+			env:  render(renderPublishEnv),
+			expected: map[string]string{
+				"REGION":       "us-west-2",
+				"NOT_A_SECRET": "see secrets.md",
+			},
+			absent: []string{"LEGACY_SECRET", "TEST_ONLY", "ARM_CLIENT_SECRET", "GITHUB_SECRET", "OSSRH_PASSWORD", "NPM_TOKEN"},
+		},
+		{
+			name: "publish job",
+			env:  render(func(v any) (string, error) { return renderJobEnv("publish", v) }),
+			expected: map[string]string{
+				"OSSRH_PASSWORD": "${{ secrets.OSSRH_PASSWORD }}",
+				"NPM_TOKEN":      "${{ secrets.NPM_TOKEN }}",
+			},
+			absent: []string{"REGION", "TEST_ONLY", "TEST_BLOCK"},
+		},
+		{
+			name:     "prerequisites job",
+			env:      render(func(v any) (string, error) { return renderJobEnv("prerequisites", v) }),
+			expected: map[string]string{"REGION": "us-east-1"},
+			absent:   []string{"NOT_A_SECRET", "OSSRH_PASSWORD"},
+		},
+		{
+			name: "local",
+			env:  render(renderLocal),
+			expected: map[string]string{
+				"LEGACY_SECRET":     "${{ steps.esc-secrets.outputs.LEGACY_SECRET }}",
+				"TEST_ONLY":         "true",
+				"ARM_CLIENT_SECRET": "${{ steps.esc-secrets.outputs.ARM_CLIENT_SECRET }}",
+				"GITHUB_SECRET":     "${{ secrets.SOME_SECRET }}",
+				"TEST_BLOCK":        "${{ steps.esc-secrets.outputs.TEST_BLOCK }}",
+			},
+			absent: []string{"REGION", "NOT_A_SECRET", "OSSRH_PASSWORD", "NPM_TOKEN"},
+		},
+	} {
+		for k, v := range c.expected {
+			if c.env[k] != v {
+				t.Fatalf("expected %s env %s to be %q, got %q", c.name, k, v, c.env[k])
+			}
+		}
+		for _, k := range c.absent {
+			if _, ok := c.env[k]; ok {
+				t.Fatalf("expected %s env to omit %s, got %v", c.name, k, c.env)
+			}
+		}
+	}
+
+	if env, err := renderJobEnv("buildSdk", config); err != nil || env != "" {
+		t.Fatalf("expected no buildSdk env, got %q, %v", env, err)
+	}
+
+	// Only the string form is rewritten.
+	if warnings := strings.Count(stderr.String(), "warning:"); warnings != 1 || !strings.Contains(stderr.String(), "LEGACY_SECRET") {
+		t.Fatalf("expected one warning about LEGACY_SECRET, got %q", stderr.String())
+	}
+}
@@ -0,0 +1,210 @@
+package pkg
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+)
+
+// generation is the in-memory result of rendering a template, before anything
+// is written to the output directory.
+type generation struct {
+	// files maps slash-separated paths, relative to the output directory, to
+	// their rendered content.
+	files map[string]generatedFile
+	// deletions lists paths, relative to the output directory, to remove before
+	// the rendered files are written. Directories are removed recursively.
+	deletions []deletion
+	// overlaid lists the files rendered from the repository's overlay.
+	overlaid []overlaidFile
+	// skipped lists the files left in place because they were modified by
+	// hand since they were last generated.
+	skipped []skippedFile
+	// version is the ci-mgmt version recorded in the manifest.
+	version string
+}
+
+type generatedFile struct {
+	data []byte
+	mode fs.FileMode
+	// layer is the template directory, overlay or manifest which produced the file.
+	layer string
+	// envSplice is true if the file's template renders the env splice slot.
+	envSplice bool
+}
+
+// deletion is a path to remove and the rule which removes it.
+type deletion struct {
+	path   string
+	source string
+}
+
+// Sources of deletions reported in FileChange.Source.
+const (
+	// sourceManifest deletes files listed in the previous manifest which are
+	// no longer generated. It is also the source of the manifest itself.
+	sourceManifest = "manifest"
+	// sourceRetired deletes files which ci-mgmt used to generate, for
+	// repositories which predate the manifest.
+	sourceRetired = "retired"
+	// sourceCleanGithubWorkflows deletes workflows not prefixed with the
+	// provider name when cleanGithubWorkflows is set.
+	sourceCleanGithubWorkflows = "cleanGithubWorkflows"
+)
+
+// delete records that p is to be removed because of source.
+func (g *generation) delete(p, source string) {
+	g.deletions = append(g.deletions, deletion{path: p, source: source})
+}
+
+// skippedFile is a file which would have been updated or deleted but was left
+// in place.
+type skippedFile struct {
+	path string
+	// source is the template directory, overlay or deletion rule which would
+	// have changed the file.
+	source string
+}
+
+// skip records that p was left in place rather than being changed by source.
+func (g *generation) skip(p, source string) {
+	g.skipped = append(g.skipped, skippedFile{path: p, source: source})
+}
+
+// overlaidFile is a file rendered from the repository's overlay directory.
+type overlaidFile struct {
+	path string
+	// replaces is the template directory whose file the overlay replaced, or
+	// empty if the overlay added a new file.
+	replaces string
+}
+
+// FileAction describes what generation does, or would do, to a single file.
+type FileAction string
+
+const (
+	FileCreated   FileAction = "created"
+	FileUpdated   FileAction = "updated"
+	FileDeleted   FileAction = "deleted"
+	FileUnchanged FileAction = "unchanged"
+	// FileSkipped is a file which would have been updated or deleted but was
+	// left in place because it was modified by hand.
+	FileSkipped FileAction = "skipped"
+)
+
+// FileChange describes what generation does, or would do, to a single file in
+// an output directory.
+type FileChange struct {
+	Path   string     `json:"path"`
+	Action FileAction `json:"action"`
+	// Source is responsible for the file: the template directory or overlay
+	// which rendered it, the rule which deleted it or the migration which
+	// modified it.
+	Source string `json:"source"`
+}
+
+// GenerateResult lists every file generation wrote, deleted, skipped or left
+// unchanged.
+type GenerateResult struct {
+	Files []FileChange `json:"files"`
+}
+
+// Changed returns the files which were created, updated or deleted.
+func (r GenerateResult) Changed() []FileChange {
+	var changed []FileChange
+	for _, f := range r.Files {
+		if f.Action != FileUnchanged && f.Action != FileSkipped {
+			changed = append(changed, f)
+		}
+	}
+	return changed
+}
+
+// write applies the generation to fsys: deletions first, then rendered files.
+func (g *generation) write(fsys WritableFS) error {
+	for _, d := range g.deletions {
+		err := fsys.RemoveAll(filepath.ToSlash(d.path))
+		if err != nil {
+			return fmt.Errorf("error deleting file %s: %w", d.path, err)
+		}
+	}
+	for _, p := range g.paths() {
+		f := g.files[p]
+		if err := fsys.WriteFile(p, f.data, f.mode); err != nil {
+			return fmt.Errorf("error writing file %s: %w", p, err)
+		}
+	}
+	return nil
+}
+
+// changes compares the generation against fsys without modifying it and
+// returns every file which would be created, updated or deleted, sorted by
+// path.
+func (g *generation) changes(fsys fs.FS) ([]FileChange, error) {
+	files, err := g.diff(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return GenerateResult{Files: files}.Changed(), nil
+}
+
+// diff compares the generation against fsys without modifying it and returns
+// every rendered, deleted or skipped file, sorted by path.
+func (g *generation) diff(fsys fs.FS) ([]FileChange, error) {
+	var changes []FileChange
+	for _, p := range g.paths() {
+		change := FileChange{Path: p, Action: FileUnchanged, Source: g.files[p].layer}
+		existing, err := fs.ReadFile(fsys, p)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			change.Action = FileCreated
+		case err != nil:
+			return nil, fmt.Errorf("error reading %s: %w", p, err)
+		case !bytes.Equal(existing, g.files[p].data):
+			change.Action = FileUpdated
+		}
+		changes = append(changes, change)
+	}
+
+	deleted := map[string]bool{}
+	for _, del := range g.deletions {
+		err := fs.WalkDir(fsys, filepath.ToSlash(del.path), func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				return nil
+			}
+			// Files which are re-rendered are replaced rather than deleted.
+			if _, ok := g.files[p]; ok || deleted[p] {
+				return nil
+			}
+			deleted[p] = true
+			changes = append(changes, FileChange{Path: p, Action: FileDeleted, Source: del.source})
+			return nil
+		})
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("error reading %s: %w", del.path, err)
+		}
+	}
+
+	for _, s := range g.skipped {
+		changes = append(changes, FileChange{Path: s.path, Action: FileSkipped, Source: s.source})
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes, nil
+}
+
+// paths returns the rendered file paths in a stable order.
+func (g *generation) paths() []string {
+	paths := make([]string, 0, len(g.files))
+	for p := range g.files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
@@ -0,0 +1,195 @@
+package pkg
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// manifestFile records every file written by the previous generation. It is
+// itself generated and committed alongside the files it lists.
+const manifestFile = ".ci-mgmt.manifest.json"
+
+// manifest lists the files owned by ci-mgmt in a repository along with the
+// hash of the content that was last generated for each.
+type manifest struct {
+	// Version is the ci-mgmt version which last changed any of the files.
+	Version string `json:"version,omitempty"`
+	// Files maps slash-separated paths, relative to the repository root, to
+	// the hex encoded SHA-256 of their generated content.
+	Files map[string]string `json:"files"`
+}
+
+// readManifest loads the manifest from fsys, returning nil if the
+// repository has never been generated with a manifest.
+func readManifest(fsys fs.FS) (*manifest, error) {
+	data, err := fs.ReadFile(fsys, manifestFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", manifestFile, err)
+	}
+	var m manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", manifestFile, err)
+	}
+	return &m, nil
+}
+
+func (m *manifest) marshal() ([]byte, error) {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+func hashContent(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// filesWithin returns the files listed in the manifest at p or, if p is a
+// directory, beneath it.
+func (m *manifest) filesWithin(p string) []string {
+	var files []string
+	for f := range m.Files {
+		if f == p || strings.HasPrefix(f, p+"/") {
+			files = append(files, f)
+		}
+	}
+	sort.Strings(files)
+	return files
+}
+
+// modifiedSinceGenerated reports whether the file at p was changed by hand
+// since the previous manifest was written. Missing files are not considered
+// modified.
+func (m *manifest) modifiedSinceGenerated(fsys fs.FS, p string) (bool, error) {
+	expected, ok := m.Files[p]
+	if !ok {
+		return false, nil
+	}
+	data, err := fs.ReadFile(fsys, p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error reading %s: %w", p, err)
+	}
+	return hashContent(data) != expected, nil
+}
+
+// reconcileManifest adds precise pruning of files listed in the previous
+// manifest to the deletions already in gen, protects files that were edited by
+// hand since they were generated and records the new manifest in gen.
+// Hand-edited files are recorded as skipped and a warning about each is written
+// to stderr.
+func reconcileManifest(gen *generation, fsys fs.FS, force bool, stderr io.Writer) error {
+	previous, err := readManifest(fsys)
+	if err != nil {
+		return err
+	}
+
+	next := &manifest{Files: map[string]string{}}
+
+	if previous != nil {
+		// Retired files and config-driven deletions still apply, unless they
+		// remove a file edited by hand since it was generated.
+		// cleanGithubWorkflows only removes the workflows the manifest lists
+		// so ones added by hand survive.
+		handled := map[string]bool{}
+		var deletions []deletion
+		for _, d := range gen.deletions {
+			paths := []string{filepath.ToSlash(d.path)}
+			if d.source == sourceCleanGithubWorkflows {
+				paths = previous.filesWithin(paths[0])
+			}
+			for _, p := range paths {
+				handled[p] = true
+				modified, err := previous.modifiedSinceGenerated(fsys, p)
+				if err != nil {
+					return err
+				}
+				if modified && !force {
+					// Files which are re-rendered are handled below.
+					if _, ok := gen.files[p]; !ok {
+						fmt.Fprintf(stderr, "warning: %s is no longer generated but was modified by hand; leaving it in place (use --force to delete)\n", p)
+						gen.skip(p, d.source)
+					}
+					continue
+				}
+				deletions = append(deletions, deletion{path: p, source: d.source})
+			}
+		}
+		gen.deletions = deletions
+
+		var prunable []string
+		for p := range previous.Files {
+			if _, ok := gen.files[p]; !ok && !handled[p] {
+				prunable = append(prunable, p)
+			}
+		}
+		sort.Strings(prunable)
+		for _, p := range prunable {
+			modified, err := previous.modifiedSinceGenerated(fsys, p)
+			if err != nil {
+				return err
+			}
+			if modified && !force {
+				fmt.Fprintf(stderr, "warning: %s is no longer generated but was modified by hand; leaving it in place (use --force to delete)\n", p)
+				gen.skip(p, sourceManifest)
+				continue
+			}
+			gen.delete(p, sourceManifest)
+		}
+
+		for _, p := range gen.paths() {
+			modified, err := previous.modifiedSinceGenerated(fsys, p)
+			if err != nil {
+				return err
+			}
+			if !modified || force {
+				continue
+			}
+			existing, err := fs.ReadFile(fsys, p)
+			if err != nil {
+				return fmt.Errorf("error reading %s: %w", p, err)
+			}
+			if hashContent(existing) == hashContent(gen.files[p].data) {
+				continue
+			}
+			fmt.Fprintf(stderr, "warning: %s was modified by hand since it was last generated; leaving it in place (use --force to overwrite)\n", p)
+			gen.skip(p, gen.files[p].layer)
+			// Keep the previous hash so the file is flagged until resolved.
+			next.Files[p] = previous.Files[p]
+			delete(gen.files, p)
+		}
+	}
+
+	for p, f := range gen.files {
+		next.Files[p] = hashContent(f.data)
+	}
+	next.Version = gen.version
+	if previous != nil && maps.Equal(previous.Files, next.Files) {
+		// Keep the manifest as it is so a new ci-mgmt version which doesn't
+		// change any file doesn't change the repository.
+		next.Version = previous.Version
+	}
+
+	data, err := next.marshal()
+	if err != nil {
+		return err
+	}
+	gen.files[manifestFile] = generatedFile{data: data, mode: 0o644, layer: sourceManifest}
+	return nil
+}
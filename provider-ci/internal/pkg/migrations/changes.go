@@ -0,0 +1,117 @@
+package migrations
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Change is a file modified by a migration.
+type Change struct {
+	// Path is slash-separated and relative to the output directory.
+	Path string
+	// Action is "created", "updated" or "deleted".
+	Action    string
+	Migration string
+}
+
+// worktreeSnapshot maps each file git reports as changed or untracked in an
+// output directory to its state. Files git reports as unchanged match HEAD and
+// are omitted.
+type worktreeSnapshot map[string]worktreeFile
+
+type worktreeFile struct {
+	// untracked is true for files which are not in HEAD or the index.
+	untracked bool
+	// hash is a hash of the file's content, or "" if it no longer exists.
+	hash string
+}
+
+// snapshotWorktree records the changed files in outDir. It returns nil if
+// outDir is not within a git worktree, in which case changes made by
+// migrations are not reported.
+func snapshotWorktree(outDir string) worktreeSnapshot {
+	prefix, err := exec.Command("git", "-C", outDir, "rev-parse", "--show-prefix").Output()
+	if err != nil {
+		return nil
+	}
+	status, err := exec.Command("git", "-C", outDir, "status", "--porcelain", "-z", "--untracked-files=all", "--", ".").Output()
+	if err != nil {
+		return nil
+	}
+
+	snapshot := worktreeSnapshot{}
+	entries := strings.Split(string(status), "\x00")
+	for i := 0; i < len(entries); i++ {
+		entry := entries[i]
+		if len(entry) < 4 {
+			continue
+		}
+		if entry[0] == 'R' || entry[0] == 'C' {
+			// Renames and copies are followed by the original path.
+			i++
+		}
+		p := strings.TrimPrefix(entry[3:], strings.TrimSpace(string(prefix)))
+		snapshot[p] = worktreeFile{
+			untracked: entry[:2] == "??",
+			hash:      hashWorktreeFile(filepath.Join(outDir, filepath.FromSlash(p))),
+		}
+	}
+	return snapshot
+}
+
+func hashWorktreeFile(path string) string {
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return ""
+	case err != nil:
+		// e.g. a modified submodule
+		data = []byte(err.Error())
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// changesSince returns the files which differ between before and after,
+// attributed to migration.
+func changesSince(before, after worktreeSnapshot, outDir, migration string) []Change {
+	paths := map[string]bool{}
+	for p := range before {
+		paths[p] = true
+	}
+	for p := range after {
+		paths[p] = true
+	}
+
+	var changes []Change
+	for p := range paths {
+		prev, changedBefore := before[p]
+		next, changedAfter := after[p]
+		if changedBefore && changedAfter && prev == next {
+			continue
+		}
+		// Files missing from before matched HEAD, unless they are new.
+		existedBefore := prev.hash != "" || !changedBefore && !next.untracked
+		if !changedAfter {
+			// Restored to match HEAD.
+			next.hash = hashWorktreeFile(filepath.Join(outDir, filepath.FromSlash(p)))
+		}
+		change := Change{Path: p, Migration: migration, Action: "updated"}
+		switch {
+		case next.hash == "":
+			change.Action = "deleted"
+		case !existedBefore:
+			change.Action = "created"
+		}
+		changes = append(changes, change)
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
@@ -0,0 +1,52 @@
+package migrations
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestMigrateReportsChanges(t *testing.T) {
+	dir := t.TempDir()
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", dir, "-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	git("init", "-q")
+	if err := os.WriteFile(filepath.Join(dir, ".ci-mgmt.yaml"), []byte("provider: aws\ntoolVersions:\n  node: 20.x\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	git("add", ".")
+	git("commit", "-q", "-m", "initial")
+
+	// A generated file which was already changed before migrations run is
+	// not attributed to them.
+	if err := os.WriteFile(filepath.Join(dir, "Makefile"), []byte("all:\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := Migrate("generic", dir, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found := map[string]Change{}
+	for _, change := range changes {
+		found[change.Path] = change
+	}
+	if _, ok := found["Makefile"]; ok {
+		t.Fatalf("expected Makefile not to be attributed to a migration, got %+v", changes)
+	}
+	overrides := migrateCimgmtOverrides{}.Name()
+	for path, action := range map[string]string{".ci-mgmt.yaml": "updated", "mise.toml": "created"} {
+		change, ok := found[path]
+		if !ok || change.Action != action || change.Migration != overrides {
+			t.Fatalf("expected %s to be %s by %q, got %+v", path, action, overrides, changes)
+		}
+	}
+}
@@ -1,8 +1,11 @@
 package migrations
 
 import (
+	"bytes"
 	"fmt"
 	"os"
+	"slices"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
@@ -12,6 +15,10 @@ type cimgmtYaml struct {
 	path string
 	// we operate on the yaml.Node so that we can preserve comments and ordering
 	node *yaml.Node
+	// indent is the file's indentation, which is preserved when writing it.
+	indent int
+	// content is the file as it was read.
+	content []byte
 }
 
 // newCimgmtYaml loads the YAML document from disk and prepares it for mutation.
@@ -28,32 +35,162 @@ func newCimgmtYaml(path string) (*cimgmtYaml, error) {
 	}
 
 	return &cimgmtYaml{
-		node: &ciMgmt,
-		path: path,
+		node:    &ciMgmt,
+		path:    path,
+		indent:  detectIndent(ciMgmtFile),
+		content: ciMgmtFile,
 	}, nil
 }
 
+// detectIndent returns the indentation of the first indented line in a YAML
+// document, or 2 if there is none.
+func detectIndent(content []byte) int {
+	for _, line := range strings.Split(string(content), "\n") {
+		trimmed := strings.TrimLeft(line, " ")
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if indent := len(line) - len(trimmed); indent > 0 {
+			return indent
+		}
+	}
+	return 2
+}
+
 // writeFile persists the in-memory YAML representation back to its original path.
 func (c *cimgmtYaml) writeFile() error {
-	newCiMgmt, err := yaml.Marshal(c.node)
-	if err != nil {
+	var newCiMgmt bytes.Buffer
+	enc := yaml.NewEncoder(&newCiMgmt)
+	enc.SetIndent(c.indent)
+	if err := enc.Encode(c.node); err != nil {
+		return fmt.Errorf("error marshaling .ci-mgmt.yaml: %w", err)
+	}
+	if err := enc.Close(); err != nil {
 		return fmt.Errorf("error marshaling .ci-mgmt.yaml: %w", err)
 	}
-	if err := os.WriteFile(c.path, newCiMgmt, 0644); err != nil {
+	if err := os.WriteFile(c.path, newCiMgmt.Bytes(), 0644); err != nil {
 		return fmt.Errorf("error writing .ci-mgmt.yaml: %w", err)
 	}
 
 	return nil
 }
 
-// deleteKey deletes a top level field from the ci-mgmt.yaml file
+// writeFileWithoutKeys deletes the given top-level fields and persists the
+// result. Only the lines of the deleted fields, and the comment directly above
+// each unless it is the first field, are removed so the rest of the file is
+// kept as it was written. Documents which can't be edited line by line, such
+// as flow mappings, are re-formatted as writeFile does.
+func (c *cimgmtYaml) writeFileWithoutKeys(keys ...string) error {
+	content, ok := c.removeTopLevelKeys(keys)
+	if !ok {
+		for _, key := range keys {
+			c.deleteKey(key)
+		}
+		return c.writeFile()
+	}
+	if err := os.WriteFile(c.path, content, 0644); err != nil {
+		return fmt.Errorf("error writing .ci-mgmt.yaml: %w", err)
+	}
+	return nil
+}
+
+// removeTopLevelKeys returns the file's content with the lines of the given
+// top-level fields removed, or false if the document isn't a block mapping.
+func (c *cimgmtYaml) removeTopLevelKeys(keys []string) ([]byte, bool) {
+	if len(c.node.Content) == 0 {
+		return c.content, true
+	}
+	root := c.node.Content[0]
+	if root.Kind != yaml.MappingNode || root.Style == yaml.FlowStyle {
+		return nil, false
+	}
+
+	lines := strings.SplitAfter(string(c.content), "\n")
+	isContent := func(line string) bool {
+		trimmed := strings.TrimSpace(line)
+		return trimmed != "" && !strings.HasPrefix(trimmed, "#")
+	}
+	remove := make([]bool, len(lines))
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key := root.Content[i]
+		if !slices.Contains(keys, key.Value) {
+			continue
+		}
+		if key.Column != 1 {
+			return nil, false
+		}
+		start := key.Line - 1
+		next := len(lines)
+		if i+2 < len(root.Content) {
+			next = root.Content[i+2].Line - 1
+		}
+		// The field ends at its last line of content. Comments and blank
+		// lines after it describe what follows.
+		end := start
+		for j := start + 1; j < next; j++ {
+			if isContent(lines[j]) {
+				end = j
+			}
+		}
+		// The comment directly above the first field is usually the
+		// file's header.
+		if i > 0 {
+			for start > 0 && !isContent(lines[start-1]) && strings.TrimSpace(lines[start-1]) != "" {
+				start--
+			}
+		}
+		for j := start; j <= end; j++ {
+			remove[j] = true
+		}
+	}
+
+	var out strings.Builder
+	for i, line := range lines {
+		if !remove[i] {
+			out.WriteString(line)
+		}
+	}
+	return []byte(out.String()), true
+}
+
+// root returns the document's top-level mapping, creating it if the document
+// is empty.
+func (c *cimgmtYaml) root() *yaml.Node {
+	if len(c.node.Content) == 0 {
+		c.node.Kind = yaml.DocumentNode
+		c.node.Content = []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}
+	}
+	return c.node.Content[0]
+}
+
+// deleteKey deletes a top level field from the ci-mgmt.yaml file. Comments
+// which don't describe the field are kept: the head comment of the first field,
+// which is usually the file's header, and any comments below the field.
 func (c *cimgmtYaml) deleteKey(key string) {
-	node := c.node.Content[0]
+	c.deletePath([]string{key})
+}
+
+// deletePath deletes the field at path, e.g. ["esc", "environment"], keeping
+// comments like deleteKey. It reports whether the field was set.
+func (c *cimgmtYaml) deletePath(path []string) bool {
+	parent := c.root()
+	if len(path) > 1 {
+		parent = c.lookup(path[:len(path)-1])
+	}
+	if parent == nil {
+		return false
+	}
+	return deleteMappingKey(parent, path[len(path)-1])
+}
+
+func deleteMappingKey(node *yaml.Node, key string) bool {
 	if node == nil || node.Kind != yaml.MappingNode {
-		return
+		return false
 	}
 
+	deleted := false
 	out := node.Content[:0]
+	var carried []string
 	for i := 0; i < len(node.Content); i += 2 {
 		if i+1 >= len(node.Content) {
 			continue
@@ -61,29 +198,102 @@ func (c *cimgmtYaml) deleteKey(key string) {
 		k := node.Content[i]
 		v := node.Content[i+1]
 		if k.Value == key {
-			continue // skip this key/value pair (delete)
+			// skip this key/value pair (delete)
+			deleted = true
+			if len(out) == 0 && k.HeadComment != "" {
+				carried = append(carried, k.HeadComment)
+			}
+			for _, comment := range []string{k.FootComment, v.FootComment} {
+				if comment != "" {
+					carried = append(carried, comment)
+				}
+			}
+			continue
+		}
+		if len(carried) > 0 {
+			k.HeadComment = joinComments(append(carried, k.HeadComment)...)
+			carried = nil
 		}
 		out = append(out, k, v)
 	}
 	node.Content = out
+	if len(carried) > 0 {
+		node.FootComment = joinComments(append(carried, node.FootComment)...)
+	}
+	return deleted
 }
 
-// getFieldNode gets a top level field from the ci-mgmt.yaml file
-func (c *cimgmtYaml) getFieldNode(key string) *yaml.Node {
-	node := c.node.Content[0]
-	if node.Kind != yaml.MappingNode {
+// lookup returns the value at path, e.g. ["esc", "environment"], or nil if it
+// is not set.
+func (c *cimgmtYaml) lookup(path []string) *yaml.Node {
+	node := c.root()
+	for _, key := range path {
+		node = mappingValue(node, key)
+		if node == nil {
+			return nil
+		}
+	}
+	return node
+}
+
+// set sets the value at path, creating any mappings leading to it. A value
+// which replaces another keeps its comments.
+func (c *cimgmtYaml) set(path []string, value *yaml.Node) error {
+	node := c.root()
+	for i, key := range path {
+		if node.Kind != yaml.MappingNode {
+			return fmt.Errorf("%s is not a mapping", strings.Join(path[:i], "."))
+		}
+		last := i == len(path)-1
+		next := mappingValue(node, key)
+		switch {
+		case next == nil:
+			next = value
+			if !last {
+				next = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			}
+			if node.Style == yaml.FlowStyle {
+				// An empty {} becomes a block mapping.
+				node.Style = 0
+			}
+			node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, next)
+		case last:
+			value.HeadComment, value.LineComment, value.FootComment = next.HeadComment, next.LineComment, next.FootComment
+			*next = *value
+		}
+		node = next
+	}
+	return nil
+}
+
+// mappingValue returns the value of key in a mapping node, or nil.
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
 		return nil
 	}
-	for i := 0; i < len(node.Content); i += 2 {
-		k := node.Content[i]
-		v := node.Content[i+1]
-		if k.Value == key {
-			return v
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
 		}
 	}
 	return nil
 }
 
+func joinComments(comments ...string) string {
+	var nonEmpty []string
+	for _, comment := range comments {
+		if comment != "" {
+			nonEmpty = append(nonEmpty, comment)
+		}
+	}
+	return strings.Join(nonEmpty, "\n")
+}
+
+// getFieldNode gets a top level field from the ci-mgmt.yaml file
+func (c *cimgmtYaml) getFieldNode(key string) *yaml.Node {
+	return c.lookup([]string{key})
+}
+
 // nodeToMap converts a yaml.Node with object data to a map[string]string
 func nodeToMap(m *yaml.Node) map[string]string {
 	if m == nil {
@@ -5,6 +5,8 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+
+	"gopkg.in/yaml.v3"
 )
 
 func TestCimgmtYamlDeleteKeyAndWrite(t *testing.T) {
@@ -63,3 +65,59 @@ func TestCimgmtYamlMissingFile(t *testing.T) {
 		t.Fatalf("expected error when file missing")
 	}
 }
+
+func TestCimgmtYamlSetAndDeleteNestedKeys(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, ".ci-mgmt.yaml")
+	initial := `# Header
+provider: foo
+esc:
+    enabled: true # keep me
+actions: {}
+`
+	if err := os.WriteFile(path, []byte(initial), 0o644); err != nil {
+		t.Fatalf("write fixture: %v", err)
+	}
+
+	cimgmt, err := newCimgmtYaml(path)
+	if err != nil {
+		t.Fatalf("newCimgmtYaml: %v", err)
+	}
+	if err := cimgmt.set([]string{"esc", "enabled"}, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: "false"}); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	if err := cimgmt.set([]string{"esc", "environment"}, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "foo"}); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	if err := cimgmt.set([]string{"actions", "preTest", "name"}, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "x"}); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	if err := cimgmt.set([]string{"provider", "name"}, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "x"}); err == nil {
+		t.Fatalf("expected setting a key within a scalar to fail")
+	}
+	if cimgmt.deletePath([]string{"esc", "missing"}) {
+		t.Fatalf("expected deleting a missing key to report false")
+	}
+	if !cimgmt.deletePath([]string{"provider"}) {
+		t.Fatalf("expected provider to be deleted")
+	}
+	if err := cimgmt.writeFile(); err != nil {
+		t.Fatalf("writeFile: %v", err)
+	}
+
+	out, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read result: %v", err)
+	}
+	expected := `# Header
+esc:
+    enabled: false # keep me
+    environment: foo
+actions:
+    preTest:
+        name: x
+`
+	if string(out) != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, out)
+	}
+}
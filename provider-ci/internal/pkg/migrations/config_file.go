@@ -0,0 +1,40 @@
+package migrations
+
+import "gopkg.in/yaml.v3"
+
+// ConfigFile is a .ci-mgmt.yaml which is edited in place, keeping its
+// comments, ordering and indentation.
+type ConfigFile struct {
+	yaml *cimgmtYaml
+}
+
+// OpenConfigFile reads the .ci-mgmt.yaml at path for editing.
+func OpenConfigFile(path string) (*ConfigFile, error) {
+	y, err := newCimgmtYaml(path)
+	if err != nil {
+		return nil, err
+	}
+	return &ConfigFile{yaml: y}, nil
+}
+
+// Get returns the value at path, e.g. ["esc", "environment"], or nil if the
+// file doesn't set it.
+func (f *ConfigFile) Get(path []string) *yaml.Node {
+	return f.yaml.lookup(path)
+}
+
+// Set sets the value at path, creating any mappings leading to it. A value
+// which replaces another keeps its comments.
+func (f *ConfigFile) Set(path []string, value *yaml.Node) error {
+	return f.yaml.set(path, value)
+}
+
+// Unset deletes the value at path and reports whether it was set.
+func (f *ConfigFile) Unset(path []string) bool {
+	return f.yaml.deletePath(path)
+}
+
+// Write saves the file.
+func (f *ConfigFile) Write() error {
+	return f.yaml.writeFile()
+}
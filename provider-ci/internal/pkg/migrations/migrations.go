@@ -2,6 +2,7 @@ package migrations
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 
@@ -14,7 +15,10 @@ type Migration interface {
 	ShouldRun(templateName string) bool
 }
 
-func Migrate(templateName, outDir string) error {
+// Migrate runs each relevant migration against outDir, reporting progress to
+// log, and returns the files they modified. Changes are only reported when
+// outDir is within a git worktree.
+func Migrate(templateName, outDir string, log io.Writer) ([]Change, error) {
 	migrations := []Migration{
 		fixupBridgeImports{},
 		removeExplicitSDKDependency{},
@@ -23,21 +27,29 @@ func Migrate(templateName, outDir string) error {
 		ignoreMiseLocal{},
 		deleteOldMiseConfig{},
 		migrateCimgmtOverrides{},
-		maintainMiseLock{},
+		removeDeprecatedConfig{},
+		maintainMiseLock{log: log},
 		unignoreSDKSchemaGo{},
 	}
+	var changes []Change
+	before := snapshotWorktree(outDir)
 	for i, migration := range migrations {
 		if !migration.ShouldRun(templateName) {
-			fmt.Printf("Migration %d: %s: skipped\n", i+1, migration.Name())
+			fmt.Fprintf(log, "Migration %d: %s: skipped\n", i+1, migration.Name())
 			continue
 		}
-		fmt.Printf("Migration %d: %s: running\n", i+1, migration.Name())
+		fmt.Fprintf(log, "Migration %d: %s: running\n", i+1, migration.Name())
 		err := migration.Migrate(templateName, outDir)
 		if err != nil {
-			return fmt.Errorf("error running migration %q: %w", migration.Name(), err)
+			return nil, fmt.Errorf("error running migration %q: %w", migration.Name(), err)
+		}
+		if before != nil {
+			after := snapshotWorktree(outDir)
+			changes = append(changes, changesSince(before, after, outDir, migration.Name())...)
+			before = after
 		}
 	}
-	return nil
+	return changes, nil
 }
 
 // Returns the path to the temporary file and a function to clean it up, or an error.
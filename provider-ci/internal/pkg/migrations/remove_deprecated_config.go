@@ -0,0 +1,43 @@
+package migrations
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+
+	"github.com/pulumi/ci-mgmt/provider-ci/internal/pkg/deprecations"
+)
+
+// removeDeprecatedConfig deletes the fields listed in the deprecations
+// registry from .ci-mgmt.yaml.
+type removeDeprecatedConfig struct{}
+
+func (removeDeprecatedConfig) Name() string {
+	return "Remove deprecated fields from .ci-mgmt.yaml"
+}
+func (removeDeprecatedConfig) ShouldRun(templateName string) bool {
+	return true
+}
+func (removeDeprecatedConfig) Migrate(templateName, outDir string) error {
+	cimgmt, err := newCimgmtYaml(filepath.Join(outDir, ".ci-mgmt.yaml"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(cimgmt.node.Content) == 0 {
+		return nil
+	}
+
+	var deprecated []string
+	for _, field := range deprecations.For(templateName) {
+		if cimgmt.getFieldNode(field.Key) != nil {
+			deprecated = append(deprecated, field.Key)
+		}
+	}
+	if len(deprecated) == 0 {
+		return nil
+	}
+	return cimgmt.writeFileWithoutKeys(deprecated...)
+}
@@ -0,0 +1,110 @@
+package migrations
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRemoveDeprecatedConfig(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		template string
+		initial  string
+		expected string
+	}{
+		{
+			name:     "keeps header and trailing comments",
+			template: "bridged-provider",
+			initial: `# yaml-language-server: $schema=ci-mgmt.schema.json
+team: ecosystem
+provider: aws # the provider
+# Deprecated, does nothing.
+makeTemplate: bridged
+# Uncomment to enable:
+# docker: true
+`,
+			expected: `# yaml-language-server: $schema=ci-mgmt.schema.json
+provider: aws # the provider
+# Uncomment to enable:
+# docker: true
+`,
+		},
+		{
+			name:     "keeps fields deprecated for other templates and indentation",
+			template: "native",
+			initial:  "provider: command\nparallel: 3\nhybrid: true\nenv:\n    A: b\n",
+			expected: "provider: command\nparallel: 3\nenv:\n    A: b\n",
+		},
+		{
+			name:     "only removes the lines of deprecated fields",
+			template: "bridged-provider",
+			initial: `provider: aws
+languages:
+    - nodejs
+    - python
+makeTemplate: bridged
+plugins:
+  - name: random
+    version: "4.16.0" # pinned
+
+# Runs before the build.
+hybrid:
+  - true
+
+env:
+  A: b
+`,
+			expected: `provider: aws
+languages:
+    - nodejs
+    - python
+plugins:
+  - name: random
+    version: "4.16.0" # pinned
+
+
+env:
+  A: b
+`,
+		},
+		{
+			name:     "removes every deprecated field",
+			template: "bridged-provider",
+			initial:  "provider: aws\nparallel: 1\nshards: 8\nmakeTemplate: bridged\nhybrid: true\nteam: ecosystem\ndisableAgenticWorkflows: true\n",
+			expected: "provider: aws\nshards: 8\n",
+		},
+		{
+			name:     "leaves files without deprecated fields untouched",
+			template: "bridged-provider",
+			initial:  "provider:   aws\nenv:\n  A: b\n",
+			expected: "provider:   aws\nenv:\n  A: b\n",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, ".ci-mgmt.yaml")
+			if err := os.WriteFile(path, []byte(tc.initial), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := (removeDeprecatedConfig{}).Migrate(tc.template, dir); err != nil {
+				t.Fatal(err)
+			}
+
+			out, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(out) != tc.expected {
+				t.Fatalf("expected:\n%s\ngot:\n%s", tc.expected, out)
+			}
+		})
+	}
+}
+
+func TestRemoveDeprecatedConfigWithoutConfig(t *testing.T) {
+	if err := (removeDeprecatedConfig{}).Migrate("bridged-provider", t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+}
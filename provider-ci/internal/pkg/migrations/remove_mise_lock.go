@@ -2,12 +2,16 @@ package migrations
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 )
 
 // Remove mise.lock file if present - we no longer generate lockfiles
-type maintainMiseLock struct{}
+type maintainMiseLock struct {
+	// log receives a message when the file is removed.
+	log io.Writer
+}
 
 func (maintainMiseLock) Name() string {
 	return "Remove mise.lock"
@@ -15,7 +19,7 @@ func (maintainMiseLock) Name() string {
 func (maintainMiseLock) ShouldRun(templateName string) bool {
 	return true
 }
-func (maintainMiseLock) Migrate(templateName, outDir string) error {
+func (m maintainMiseLock) Migrate(templateName, outDir string) error {
 	miseLockPath := filepath.Join(outDir, ".config", "mise.lock")
 	_, err := os.Stat(miseLockPath)
 	if err == nil {
@@ -23,7 +27,7 @@ func (maintainMiseLock) Migrate(templateName, outDir string) error {
 		if err := os.Remove(miseLockPath); err != nil {
 			return fmt.Errorf("error removing mise.lock: %w", err)
 		}
-		fmt.Println("Removed mise.lock file")
+		fmt.Fprintln(m.log, "Removed mise.lock file")
 	} else if !os.IsNotExist(err) {
 		// Some other error occurred
 		return fmt.Errorf("error checking mise.lock: %w", err)
@@ -0,0 +1,91 @@
+package pkg
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing/fstest"
+)
+
+// WritableFS is the filesystem generation reads existing files from and
+// writes rendered files to. Names are slash-separated and relative to the root
+// of the repository being generated, as with fs.FS.
+type WritableFS interface {
+	fs.FS
+	// WriteFile writes data to name, creating any missing parent directories.
+	// Executable bits in perm are always applied; other bits only apply to
+	// newly created files.
+	WriteFile(name string, data []byte, perm fs.FileMode) error
+	// RemoveAll removes name and any children it contains. It returns nil if
+	// name does not exist.
+	RemoveAll(name string) error
+}
+
+// NewOSFS returns a WritableFS backed by the directory dir on disk.
+func NewOSFS(dir string) WritableFS {
+	return osFS{FS: os.DirFS(dir), dir: dir}
+}
+
+type osFS struct {
+	fs.FS
+	dir string
+}
+
+func (o osFS) path(name string) string {
+	return filepath.Join(o.dir, filepath.FromSlash(name))
+}
+
+func (o osFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	p := o.path(name)
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(p, data, perm); err != nil {
+		return err
+	}
+	// WriteFile only applies the mode to new files.
+	if perm&0o111 != 0 {
+		return os.Chmod(p, perm)
+	}
+	return nil
+}
+
+func (o osFS) RemoveAll(name string) error {
+	return os.RemoveAll(o.path(name))
+}
+
+// MemFS is an in-memory WritableFS. It lets callers render a repository
+// without touching disk, inspect the result and decide what to do with it.
+// The zero value is not usable; create one with NewMemFS.
+type MemFS struct {
+	fstest.MapFS
+}
+
+// NewMemFS returns an empty in-memory filesystem.
+func NewMemFS() *MemFS {
+	return &MemFS{MapFS: fstest.MapFS{}}
+}
+
+func (m *MemFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: "write", Path: name, Err: fs.ErrInvalid}
+	}
+	if existing, ok := m.MapFS[name]; ok {
+		perm = existing.Mode | perm&0o111
+	}
+	m.MapFS[name] = &fstest.MapFile{Data: append([]byte(nil), data...), Mode: perm}
+	return nil
+}
+
+func (m *MemFS) RemoveAll(name string) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: "removeall", Path: name, Err: fs.ErrInvalid}
+	}
+	for p := range m.MapFS {
+		if p == name || name == "." || strings.HasPrefix(p, name+"/") {
+			delete(m.MapFS, p)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package pkg
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+)
+
+// autogeneratedBanner begins the warning at the top of most templates.
+const autogeneratedBanner = "# WARNING: This file is autogenerated"
+
+// hashCommentFiles are the file extensions and names which use # comments and
+// so receive a provenance header.
+var hashCommentFiles = map[string]bool{
+	".yml":           true,
+	".yaml":          true,
+	".sh":            true,
+	".py":            true,
+	".toml":          true,
+	".mk":            true,
+	"Makefile":       true,
+	".gitattributes": true,
+}
+
+// addProvenanceHeader records the template which produced a file in a comment
+// after its shebang and autogenerated banner, if it has them. Files without #
+// comments are returned unchanged. The ci-mgmt version is only recorded in the
+// manifest so files don't change when an unrelated template does.
+func addProvenanceHeader(outPath, source string, data []byte) []byte {
+	name := path.Base(outPath)
+	if !hashCommentFiles[name] && !hashCommentFiles[path.Ext(name)] {
+		return data
+	}
+	header := fmt.Sprintf("# Generated by ci-mgmt from template %s\n", source)
+
+	offset := 0
+	for _, prefix := range []string{"#!", autogeneratedBanner} {
+		rest := data[offset:]
+		if !bytes.HasPrefix(rest, []byte(prefix)) {
+			continue
+		}
+		end := bytes.IndexByte(rest, '\n')
+		if end < 0 {
+			data = append(bytes.Clone(data), '\n')
+			offset = len(data)
+			break
+		}
+		offset += end + 1
+	}
+
+	out := make([]byte, 0, len(data)+len(header))
+	out = append(out, data[:offset]...)
+	out = append(out, header...)
+	return append(out, data[offset:]...)
+}
@@ -0,0 +1,26 @@
+package pkg
+
+import "testing"
+
+func TestAddProvenanceHeader(t *testing.T) {
+	const header = "# Generated by ci-mgmt from template base/x\n"
+	const banner = autogeneratedBanner + " - changes will be overwritten\n"
+	for _, tc := range []struct {
+		outPath  string
+		data     string
+		expected string
+	}{
+		{".github/workflows/test.yml", banner + "name: test\n", banner + header + "name: test\n"},
+		{"scripts/upstream.sh", "#!/usr/bin/env bash\n" + banner + "set -e\n", "#!/usr/bin/env bash\n" + banner + header + "set -e\n"},
+		{"setup.py", "#!/usr/bin/env python3\nprint()\n", "#!/usr/bin/env python3\n" + header + "print()\n"},
+		{"Makefile", "all:\n", header + "all:\n"},
+		{".gitattributes", "* text", header + "* text"},
+		{".github/workflows/test.yml", banner[:len(banner)-1], banner + header},
+		{"devbox.json", "{}\n", "{}\n"},
+		{"README.md", "# Title\n", "# Title\n"},
+	} {
+		if got := string(addProvenanceHeader(tc.outPath, "base/x", []byte(tc.data))); got != tc.expected {
+			t.Fatalf("%s: expected:\n%s\ngot:\n%s", tc.outPath, tc.expected, got)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package pkg
+
+import (
+	_ "embed" // For embedding the Config source.
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"reflect"
+	"strings"
+
+	"github.com/pulumi/ci-mgmt/provider-ci/internal/pkg/deprecations"
+)
+
+// configSource is parsed for the doc comments of Config and its fields.
+//
+//go:embed config.go
+var configSource []byte
+
+// schemaURL is where the JSON Schema for .ci-mgmt.yaml is published.
+const schemaURL = "https://raw.githubusercontent.com/pulumi/ci-mgmt/master/provider-ci/ci-mgmt.schema.json"
+
+// supportedLanguages are the SDK languages which may be listed in `languages`.
+var supportedLanguages = []string{"nodejs", "python", "dotnet", "go", "java"}
+
+// ConfigSchema returns a JSON Schema describing .ci-mgmt.yaml. It is derived
+// from the Config struct: property names from yaml tags, descriptions from doc
+// comments and defaults from the embedded default configuration, along with
+// the implicit defaults for its template.
+func ConfigSchema() (map[string]any, error) {
+	docs, err := parseConfigDocs(configSource)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing config docs: %w", err)
+	}
+	defaults, err := loadDefaultConfig()
+	if err != nil {
+		return nil, err
+	}
+	ApplyImplicitDefaults(&defaults, defaults.Template, "")
+
+	b := schemaBuilder{docs: docs}
+	schema := b.build(reflect.TypeOf(Config{}), reflect.ValueOf(defaults), "Config")
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = ".ci-mgmt.yaml"
+	schema["required"] = []string{"provider"}
+
+	properties := schema["properties"].(map[string]any)
+	properties["template"].(map[string]any)["enum"] = templateNames
+	properties["languages"].(map[string]any)["items"].(map[string]any)["enum"] = supportedLanguages
+	runner := properties["runner"].(map[string]any)["properties"].(map[string]any)
+	runner["testOs"].(map[string]any)["items"].(map[string]any)["enum"] = testOSes
+	for _, field := range deprecations.Fields {
+		if len(field.Templates) == 0 {
+			properties[field.Key].(map[string]any)["deprecated"] = true
+		}
+	}
+	return schema, nil
+}
+
+type schemaBuilder struct {
+	// docs maps "Type" and "Type.Field" to doc comments. Fields of anonymous
+	// structs are keyed by the path to them, e.g. "Config.Runner.Default".
+	docs map[string]string
+}
+
+// build returns the schema for t. defaults is the default value of t, if any,
+// and key is the docs key for t when it is an anonymous struct.
+func (b schemaBuilder) build(t reflect.Type, defaults reflect.Value, key string) map[string]any {
+	switch t {
+	case reflect.TypeOf(intOrBool(false)):
+		return map[string]any{"type": []string{"boolean", "integer"}}
+	case reflect.TypeOf(intOrDuration(0)):
+		return map[string]any{"type": []string{"integer", "string"}}
+	case reflect.TypeOf(&runner{}):
+		labels := map[string]any{"type": "array", "items": map[string]any{"type": "string"}}
+		group := b.buildStruct(t.Elem(), reflect.Value{}, key)
+		group["properties"].(map[string]any)["labels"] = map[string]any{"anyOf": []any{map[string]any{"type": "string"}, labels}}
+		group["anyOf"] = []any{
+			map[string]any{"required": []string{"group"}},
+			map[string]any{"required": []string{"labels"}},
+		}
+		schema := map[string]any{"anyOf": []any{map[string]any{"type": "string"}, labels, group}}
+		if defaults.IsValid() && !defaults.IsNil() {
+			value, _ := defaults.Interface().(*runner).MarshalYAML()
+			schema["default"] = value
+		}
+		return schema
+	case reflect.TypeOf(EnvVar{}):
+		envVar := b.buildStruct(t, defaults, key)
+		envVar["properties"].(map[string]any)["scope"].(map[string]any)["enum"] = envScopes
+		envVar["oneOf"] = []any{
+			map[string]any{"required": []string{"value"}},
+			map[string]any{"required": []string{"secret"}},
+			map[string]any{"required": []string{"esc"}},
+		}
+		return map[string]any{"anyOf": []any{map[string]any{"type": "string"}, envVar}}
+	}
+
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+		if defaults.IsValid() && !defaults.IsNil() {
+			defaults = defaults.Elem()
+		} else {
+			defaults = reflect.Value{}
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int64:
+		return map[string]any{"type": "integer"}
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": b.build(t.Elem(), reflect.Value{}, key)}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": b.build(t.Elem(), reflect.Value{}, key)}
+	case reflect.Struct:
+		return b.buildStruct(t, defaults, key)
+	default:
+		// Untyped fields, e.g. workflow steps, accept anything.
+		return map[string]any{}
+	}
+}
+
+// buildStruct returns the schema for the struct type t.
+func (b schemaBuilder) buildStruct(t reflect.Type, defaults reflect.Value, key string) map[string]any {
+	if t.Name() != "" {
+		key = t.Name()
+	}
+	properties := map[string]any{}
+	for i := range t.NumField() {
+		field := t.Field(i)
+		name, ok := yamlFieldName(field)
+		if !ok {
+			continue
+		}
+		var fieldDefault reflect.Value
+		if defaults.IsValid() {
+			fieldDefault = defaults.Field(i)
+		}
+
+		property := b.build(field.Type, fieldDefault, key+"."+field.Name)
+		if doc := b.docs[key+"."+field.Name]; doc != "" {
+			property["description"] = doc
+			if strings.HasPrefix(doc, "Deprecated:") {
+				property["deprecated"] = true
+			}
+		}
+		if fieldDefault.IsValid() && !fieldDefault.IsZero() && field.Type.Kind() != reflect.Struct && field.Type.Kind() != reflect.Pointer {
+			property["default"] = fieldDefault.Interface()
+		}
+		properties[name] = property
+		if field.Type.Kind() == reflect.Slice && name != "extends" {
+			properties[name+appendSuffix] = map[string]any{
+				"type":        "array",
+				"items":       property["items"],
+				"description": fmt.Sprintf("Appended to the inherited %s rather than replacing it (see extends).", name),
+			}
+		}
+	}
+	schema := map[string]any{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+	if doc := b.docs[key]; doc != "" {
+		schema["description"] = doc
+	}
+	return schema
+}
+
+// yamlFieldName returns the key used for field in YAML, following the rules
+// of gopkg.in/yaml.v3.
+func yamlFieldName(field reflect.StructField) (string, bool) {
+	if !field.IsExported() {
+		return "", false
+	}
+	name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+	switch name {
+	case "-":
+		return "", false
+	case "":
+		return strings.ToLower(field.Name), true
+	}
+	return name, true
+}
+
+// parseConfigDocs collects the doc comments of every type declared in src and
+// of their fields.
+func parseConfigDocs(src []byte) (map[string]string, error) {
+	file, err := parser.ParseFile(token.NewFileSet(), "config.go", src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	docs := map[string]string{}
+	var collectFields func(key string, st *ast.StructType)
+	collectFields = func(key string, st *ast.StructType) {
+		for _, field := range st.Fields.List {
+			for _, name := range field.Names {
+				fieldKey := key + "." + name.Name
+				docs[fieldKey] = docText(field.Doc)
+				if nested, ok := field.Type.(*ast.StructType); ok {
+					collectFields(fieldKey, nested)
+				}
+			}
+		}
+	}
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			ts := spec.(*ast.TypeSpec)
+			doc := ts.Doc
+			if doc == nil {
+				doc = gen.Doc
+			}
+			docs[ts.Name.Name] = docText(doc)
+			if st, ok := ts.Type.(*ast.StructType); ok {
+				collectFields(ts.Name.Name, st)
+			}
+		}
+	}
+	return docs, nil
+}
+
+// docText unwraps a doc comment into paragraphs separated by blank lines.
+func docText(doc *ast.CommentGroup) string {
+	if doc == nil {
+		return ""
+	}
+	var paragraphs []string
+	for _, paragraph := range strings.Split(strings.TrimSpace(doc.Text()), "\n\n") {
+		lines := strings.Split(paragraph, "\n")
+		// Indented paragraphs are examples, whose lines are kept.
+		if strings.HasPrefix(lines[0], "\t") || strings.HasPrefix(lines[0], "    ") {
+			indent := lines[0][:len(lines[0])-len(strings.TrimLeft(lines[0], " \t"))]
+			for i := range lines {
+				lines[i] = strings.TrimPrefix(lines[i], indent)
+			}
+			paragraphs = append(paragraphs, strings.Join(lines, "\n"))
+			continue
+		}
+		for i := range lines {
+			lines[i] = strings.TrimSpace(lines[i])
+		}
+		paragraphs = append(paragraphs, strings.Join(lines, " "))
+	}
+	return strings.Join(paragraphs, "\n\n")
+}
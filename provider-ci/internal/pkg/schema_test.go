@@ -0,0 +1,102 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestConfigSchema(t *testing.T) {
+	schema, err := ConfigSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	properties := schema["properties"].(map[string]any)
+
+	template := properties["template"].(map[string]any)
+	if !reflect.DeepEqual(template["enum"], templateNames) {
+		t.Fatalf("expected template enum %v, got %v", templateNames, template["enum"])
+	}
+	if template["default"] != "bridged-provider" {
+		t.Fatalf("expected template default from defaults.config.yaml, got %v", template["default"])
+	}
+	languages := properties["languages"].(map[string]any)
+	if !reflect.DeepEqual(languages["items"].(map[string]any)["enum"], supportedLanguages) {
+		t.Fatalf("expected languages enum %v, got %v", supportedLanguages, languages["items"])
+	}
+
+	provider := properties["provider"].(map[string]any)
+	if provider["description"] != "Provider is required and is the name of the provider without the \"pulumi-\" prefix." {
+		t.Fatalf("expected provider description from its doc comment, got %q", provider["description"])
+	}
+	githubApp := properties["github-app"].(map[string]any)["properties"].(map[string]any)
+	for _, key := range []string{"enabled", "id", "private-key"} {
+		if _, ok := githubApp[key]; !ok {
+			t.Fatalf("expected github-app.%s in schema, got %v", key, githubApp)
+		}
+	}
+	runner := properties["runner"].(map[string]any)["properties"].(map[string]any)
+	if runner["default"].(map[string]any)["default"] != "ubuntu-latest" {
+		t.Fatalf("expected runner.default default, got %v", runner["default"])
+	}
+	if _, ok := properties["disableAgenticWorkflows"].(map[string]any)["deprecated"]; !ok {
+		t.Fatal("expected disableAgenticWorkflows to be deprecated")
+	}
+}
+
+func TestConfigSchemaAcceptsTestProviderConfigs(t *testing.T) {
+	schema, err := ConfigSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths, err := filepath.Glob("../../test-providers/*/.ci-mgmt.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatal("expected test provider configs")
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var config map[string]any
+		if err := yaml.Unmarshal(data, &config); err != nil {
+			t.Fatal(err)
+		}
+		checkSchemaKeys(t, path, schema, config)
+	}
+}
+
+// checkSchemaKeys fails if value has any object keys which schema does not
+// declare.
+func checkSchemaKeys(t *testing.T, path string, schema map[string]any, value any) {
+	t.Helper()
+	switch value := value.(type) {
+	case map[string]any:
+		properties, ok := schema["properties"].(map[string]any)
+		for key, v := range value {
+			if !ok {
+				if additional, ok := schema["additionalProperties"].(map[string]any); ok {
+					checkSchemaKeys(t, path, additional, v)
+				}
+				continue
+			}
+			property, known := properties[key]
+			if !known {
+				t.Fatalf("%s: %s is not in the schema", path, key)
+			}
+			checkSchemaKeys(t, path, property.(map[string]any), v)
+		}
+	case []any:
+		if items, ok := schema["items"].(map[string]any); ok {
+			for _, v := range value {
+				checkSchemaKeys(t, path, items, v)
+			}
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+)
+
+// spliceDir is the directory within a provider repository containing splice
+// files which fill or extend the slots exposed by the shared templates.
+const spliceDir = ".ci-mgmt/splices"
+
+// spliceSlots are the named extension points exposed by the shared templates.
+// Templates reference a slot as .Splices.<name>; a provider fills it by adding
+// <name>.splice to spliceDir.
+var spliceSlots = map[string]string{
+	"env":          "extra environment variables for every generated workflow",
+	"testSteps":    "extra steps run before the tests in every test job",
+	"releaseSteps": "extra steps run after the provider binaries are published",
+}
+
+// collectProviderSplices renders the repository's own splice files. It fails
+// if any file does not name a slot in spliceSlots.
+func collectProviderSplices(fsys fs.FS, tc templateContext) (map[string]string, error) {
+	if _, err := fs.Stat(fsys, spliceDir); errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	splices, err := collectSplices(fsys, spliceDir, tc)
+	if err != nil {
+		return nil, err
+	}
+
+	var unknown []string
+	for name, content := range splices {
+		if _, ok := spliceSlots[name]; !ok {
+			unknown = append(unknown, name)
+			continue
+		}
+		splices[name] = strings.TrimRight(content, "\n")
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		known := make([]string, 0, len(spliceSlots))
+		for name := range spliceSlots {
+			known = append(known, name)
+		}
+		sort.Strings(known)
+		return nil, fmt.Errorf("unknown splice slot(s) %s in %s; known slots are %s",
+			strings.Join(unknown, ", "), spliceDir, strings.Join(known, ", "))
+	}
+	return splices, nil
+}
+
+// mergeSplices extends the splices defined by a template directory with the
+// provider's own splices. Provider content is appended after any default.
+func mergeSplices(splices, provider map[string]string) {
+	for name, content := range provider {
+		if existing := strings.TrimRight(splices[name], "\n"); existing != "" {
+			content = existing + "\n" + content
+		}
+		splices[name] = content
+	}
+}
+
+// warnIgnoredEnvSplice warns about each generated workflow whose template
+// doesn't render the env splice slot, such as one replaced by an overlay.
+func warnIgnoredEnvSplice(gen *generation, stderr io.Writer) {
+	var ignored []string
+	for p, f := range gen.files {
+		if path.Dir(p) == ".github/workflows" && path.Ext(p) == ".yml" && !f.envSplice {
+			ignored = append(ignored, p)
+		}
+	}
+	sort.Strings(ignored)
+	for _, p := range ignored {
+		fmt.Fprintf(stderr, "warning: %s/env.splice is not rendered into %s\n", spliceDir, p)
+	}
+}
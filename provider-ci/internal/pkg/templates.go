@@ -1,7 +1,12 @@
 package pkg
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
 )
 
 // TemplateDir is a directory in the embedded filesystem that contains files to be rendered into the output directory.
@@ -30,7 +35,7 @@ const (
 // getTemplateDirs returns a list of directories in the embedded filesystem that form the overall template.
 // Templates are composed of one or more template folders within this directory.
 // Each directory is rendered into the same output directory.
-// Care should be taken to ensure that the template files do not conflict with each other.
+// A file may only be produced by more than one directory if it is declared in templateOverrides.
 func getTemplateDirs(templateName string) ([]TemplateDir, error) {
 	// Note: Render more specific templates last to allow them to override more general templates.
 	// The `.ci-mgmt.yaml` `template` property can be set to one of 3 values:
@@ -47,10 +52,59 @@ func getTemplateDirs(templateName string) ([]TemplateDir, error) {
 		// Pulumi-owned providers not based on tf-bridge
 		return []TemplateDir{base, internal, all}, nil
 	case "parameterized-go":
-		return []TemplateDir{base, parameterizedGo, all /* overrides Makefile */}, nil
+		return []TemplateDir{base, parameterizedGo, all}, nil
 	case "native":
 		return []TemplateDir{native, internal, all}, nil // Can't use base because it has a Makefile that would conflict
 	default:
 		return nil, fmt.Errorf("unknown template: %s", templateName)
 	}
 }
+
+// templateOverrides declares the files which a template directory intentionally
+// replaces when it is rendered after another directory in the same template.
+// Any other file produced by more than one directory is an error.
+var templateOverrides = map[TemplateDir]map[string]TemplateDir{
+	all: {
+		".gitattributes": base,
+	},
+	internalBridged: {
+		".github/workflows/main-post-build.yml": base,
+	},
+	parameterizedGo: {
+		"Makefile": base,
+	},
+}
+
+// composeTemplateDirs maps every file in the given template directories, by
+// its path relative to the directory, to the directory which provides it. It
+// fails if a file is produced by more than one directory without a matching
+// entry in overrides.
+func composeTemplateDirs(fsys fs.FS, root string, dirs []TemplateDir, overrides map[TemplateDir]map[string]TemplateDir) (map[string]TemplateDir, error) {
+	sources := map[string]TemplateDir{}
+	var collisions []error
+	for _, dir := range dirs {
+		dirPath := path.Join(root, string(dir))
+		err := fs.WalkDir(fsys, dirPath, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() || strings.HasSuffix(p, ".splice") {
+				return nil
+			}
+			rel := strings.TrimPrefix(p, dirPath+"/")
+			if previous, ok := sources[rel]; ok && overrides[dir][rel] != previous {
+				collisions = append(collisions, fmt.Errorf("%s is produced by both %s and %s", rel, previous, dir))
+			}
+			sources[rel] = dir
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(collisions) > 0 {
+		sort.Slice(collisions, func(i, j int) bool { return collisions[i].Error() < collisions[j].Error() })
+		return nil, fmt.Errorf("conflicting template files (declare intended overrides in templateOverrides): %w", errors.Join(collisions...))
+	}
+	return sources, nil
+}
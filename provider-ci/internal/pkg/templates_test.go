@@ -0,0 +1,203 @@
+package pkg
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestTemplatesComposeWithoutUndeclaredCollisions(t *testing.T) {
+	for _, name := range templateNames {
+		t.Run(name, func(t *testing.T) {
+			dirs, err := getTemplateDirs(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := composeTemplateDirs(embeddedTemplates, ".", dirs, templateOverrides); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestComposeTemplateDirsReportsCollisions(t *testing.T) {
+	fsys := fstest.MapFS{
+		"templates/base/Makefile":           {},
+		"templates/base/README.md":          {},
+		"templates/base/main.splice":        {},
+		"templates/all/Makefile":            {},
+		"templates/all/README.md":           {},
+		"templates/all/main.splice":         {},
+		"templates/all/.github/lint.yml":    {},
+		"templates/native/.github/lint.yml": {},
+	}
+
+	sources, err := composeTemplateDirs(fsys, "templates", []TemplateDir{base, all}, map[TemplateDir]map[string]TemplateDir{
+		all: {"Makefile": base, "README.md": base},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sources["Makefile"] != all || sources[".github/lint.yml"] != all {
+		t.Fatalf("expected later layers to win, got %#v", sources)
+	}
+
+	_, err = composeTemplateDirs(fsys, "templates", []TemplateDir{base, all, native}, map[TemplateDir]map[string]TemplateDir{
+		all: {"Makefile": base},
+	})
+	if err == nil {
+		t.Fatal("expected undeclared collisions to be reported")
+	}
+	for _, expected := range []string{
+		"README.md is produced by both base and all",
+		".github/lint.yml is produced by both all and native",
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Fatalf("expected error to contain %q, got: %v", expected, err)
+		}
+	}
+	if strings.Contains(err.Error(), "Makefile") || strings.Contains(err.Error(), "splice") {
+		t.Fatalf("expected declared overrides and splices to be allowed, got: %v", err)
+	}
+}
+
+func TestLoadTemplateSourceFallsBackToEmbeddedTemplates(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "all", ".github", "workflows"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "all", ".github", "workflows", "lint.yml"), []byte("name: forked lint\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	templates, err := LoadTemplateSource(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names, err := ListTemplates(templates)
+	if err != nil {
+		t.Fatal(err)
+	}
+	embeddedNames, err := ListTemplates(embeddedTemplates)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != len(embeddedNames) {
+		t.Fatalf("expected %v, got %v", embeddedNames, names)
+	}
+	if _, err := loadDefaultConfigFrom(templates); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := loadDefaultConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Provider = "aws"
+	config.ESC.Enabled = true
+	fsys := NewMemFS()
+	if _, err := GeneratePackage(GenerateOpts{
+		RepositoryName: "pulumi/pulumi-aws",
+		TemplateName:   "bridged-provider",
+		Config:         config,
+		FS:             fsys,
+		Templates:      templates,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	lint, err := fs.ReadFile(fsys, ".github/workflows/lint.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(string(lint), "\nname: forked lint\n") {
+		t.Fatalf("expected lint.yml from the template source, got:\n%s", lint)
+	}
+	if _, err := fs.Stat(fsys, ".github/workflows/master.yml"); err != nil {
+		t.Fatalf("expected base templates to fall back to the embedded set: %v", err)
+	}
+}
+
+// writeTemplateArchive writes files to a gzipped tar archive and returns its
+// path.
+func writeTemplateArchive(t *testing.T, files map[string]string) string {
+	t.Helper()
+	archive := filepath.Join(t.TempDir(), "templates.tgz")
+	f, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, c := range []interface{ Close() error }{tw, gz, f} {
+		if err := c.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return archive
+}
+
+func TestLoadTemplateSourceReadsArchives(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		file string
+	}{
+		{name: "wrapped in templates", path: "templates/all/.github/workflows/lint.yml", file: "all/.github/workflows/lint.yml"},
+		{name: "wrapped in a versioned directory", path: "templates-1.2/base/.github/workflows/master.yml", file: "base/.github/workflows/master.yml"},
+		// A single template directory is not a wrapper to strip.
+		{name: "base only", path: "base/.github/workflows/master.yml", file: "base/.github/workflows/master.yml"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			content := "name: forked\n"
+			templates, err := LoadTemplateSource(writeTemplateArchive(t, map[string]string{tc.path: content}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := fs.ReadFile(templates, tc.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != content {
+				t.Fatalf("expected %s from the archive, got:\n%s", tc.file, data)
+			}
+		})
+	}
+}
+
+func TestLoadTemplateSourceRejectsArchivesWithoutTemplates(t *testing.T) {
+	archive := writeTemplateArchive(t, map[string]string{"templates-1.2/other/lint.yml": "name: lint\n"})
+
+	_, err := LoadTemplateSource(archive)
+	if err == nil || !strings.Contains(err.Error(), "contains none of the template directories") {
+		t.Fatalf("expected an error for an archive without templates, got %v", err)
+	}
+}
+
+func TestLoadTemplateSourceValidatesTemplates(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "all"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "all", "Makefile"), []byte("all:\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := LoadTemplateSource(dir)
+	if err == nil || !strings.Contains(err.Error(), "Makefile is produced by both") {
+		t.Fatalf("expected a collision with the embedded Makefile, got %v", err)
+	}
+}
@@ -0,0 +1,215 @@
+package pkg
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"testing/fstest"
+)
+
+// embeddedTemplates is the template set compiled into provider-ci, rooted at
+// the templates directory.
+var embeddedTemplates = func() fs.FS {
+	sub, err := fs.Sub(templateFS, "templates")
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}()
+
+// templateNames are the templates which may be selected with `template` in
+// .ci-mgmt.yaml (see getTemplateDirs).
+var templateNames = []string{
+	"bridged-provider",
+	"external-bridged-provider",
+	"external-native-provider",
+	"generic",
+	"parameterized-go",
+	"native",
+}
+
+// LoadTemplateSource returns the template set at source: a directory or a
+// .tar, .tar.gz or .tgz archive with the same layout as the embedded templates
+// directory. Any top-level entry missing from source, such as a template
+// directory or defaults.config.yaml, is taken from the embedded templates. An
+// empty source returns the embedded templates.
+//
+// Every template is checked to compose without undeclared collisions, as the
+// embedded templates are.
+func LoadTemplateSource(source string) (fs.FS, error) {
+	if source == "" {
+		return embeddedTemplates, nil
+	}
+
+	info, err := os.Stat(source)
+	if err != nil {
+		return nil, fmt.Errorf("error reading template source: %w", err)
+	}
+	var fsys fs.FS
+	switch {
+	case info.IsDir():
+		fsys = os.DirFS(source)
+	case strings.HasSuffix(source, ".tar"), strings.HasSuffix(source, ".tar.gz"), strings.HasSuffix(source, ".tgz"):
+		fsys, err = readTemplateArchive(source)
+		if err != nil {
+			return nil, fmt.Errorf("error reading template source %s: %w", source, err)
+		}
+	default:
+		return nil, fmt.Errorf("template source %s must be a directory or a .tar, .tar.gz or .tgz archive", source)
+	}
+
+	if !providesTemplates(fsys) {
+		return nil, fmt.Errorf("template source %s contains none of the template directories (%s) or %s",
+			source, strings.Join(templateDirNames(), ", "), defaultsConfigPath)
+	}
+
+	templates := fallbackFS{primary: fsys, fallback: embeddedTemplates}
+	for _, name := range templateNames {
+		dirs, err := getTemplateDirs(name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := composeTemplateDirs(templates, ".", dirs, templateOverrides); err != nil {
+			return nil, fmt.Errorf("invalid template source %s: template %s: %w", source, name, err)
+		}
+	}
+	return templates, nil
+}
+
+// templateDirNames returns the names of the template directories which a
+// template source may provide.
+func templateDirNames() []string {
+	return []string{string(all), string(base), string(internal), string(bridged), string(internalBridged), parameterizedGo, native}
+}
+
+// isTemplateRootEntry reports whether name is a top-level entry of the
+// templates directory: a template directory or the config defaults.
+func isTemplateRootEntry(name string) bool {
+	return name == defaultsConfigPath || slices.Contains(templateDirNames(), name)
+}
+
+// providesTemplates reports whether fsys has any template directory or the
+// config defaults at its root.
+func providesTemplates(fsys fs.FS) bool {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return false
+	}
+	return slices.ContainsFunc(entries, func(entry fs.DirEntry) bool { return isTemplateRootEntry(entry.Name()) })
+}
+
+// readTemplateArchive reads a tar archive, optionally gzipped, into memory. If
+// every entry is within a single top-level directory which wraps the template
+// directories, e.g. when the archive was created with
+// `tar -czf templates.tgz templates`, that directory is treated as the root.
+// An archive with a single template directory, such as base/, is left as it
+// is.
+func readTemplateArchive(name string) (fs.FS, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if !strings.HasSuffix(name, ".tar") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	files := fstest.MapFS{}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		p := path.Clean(strings.TrimPrefix(hdr.Name, "./"))
+		if !fs.ValidPath(p) {
+			return nil, fmt.Errorf("invalid path in archive: %s", hdr.Name)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		files[p] = &fstest.MapFile{Data: data, Mode: fs.FileMode(hdr.Mode).Perm()}
+	}
+
+	roots := map[string]bool{}
+	wrapsTemplates := false
+	for p := range files {
+		root, rest, _ := strings.Cut(p, "/")
+		roots[root] = true
+		entry, _, _ := strings.Cut(rest, "/")
+		wrapsTemplates = wrapsTemplates || isTemplateRootEntry(entry)
+	}
+	if len(roots) == 1 && wrapsTemplates {
+		for root := range roots {
+			if _, isFile := files[root]; !isFile && !isTemplateRootEntry(root) {
+				return fs.Sub(files, root)
+			}
+		}
+	}
+	return files, nil
+}
+
+// fallbackFS serves each top-level entry from primary if it exists there and
+// from fallback otherwise.
+type fallbackFS struct {
+	primary, fallback fs.FS
+}
+
+func (f fallbackFS) pick(name string) fs.FS {
+	root, _, _ := strings.Cut(name, "/")
+	if _, err := fs.Stat(f.primary, root); err == nil {
+		return f.primary
+	}
+	return f.fallback
+}
+
+func (f fallbackFS) Open(name string) (fs.File, error) {
+	if name == "." {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: errors.New("use ReadDir to list the template root")}
+	}
+	return f.pick(name).Open(name)
+}
+
+func (f fallbackFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if name != "." {
+		return fs.ReadDir(f.pick(name), name)
+	}
+	entries := map[string]fs.DirEntry{}
+	for _, fsys := range []fs.FS{f.fallback, f.primary} {
+		dirEntries, err := fs.ReadDir(fsys, ".")
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range dirEntries {
+			entries[entry.Name()] = entry
+		}
+	}
+	merged := make([]fs.DirEntry, 0, len(entries))
+	for _, entry := range entries {
+		merged = append(merged, entry)
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].Name() < merged[j].Name() })
+	return merged, nil
+}
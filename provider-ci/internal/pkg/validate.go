@@ -0,0 +1,726 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pulumi/ci-mgmt/provider-ci/internal/pkg/deprecations"
+	"gopkg.in/yaml.v3"
+)
+
+// Severity is how serious a Diagnostic is. Generation refuses to run while a
+// config has any errors.
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// Diagnostic is a problem found in a .ci-mgmt.yaml file.
+type Diagnostic struct {
+	// Path is the config file the problem was found in.
+	Path string
+	// Line and Column locate the problem, starting from 1. Column is 0 if
+	// only the line is known.
+	Line     int
+	Column   int
+	Severity Severity
+	Message  string
+	// Suggestion describes how to fix the problem, if known.
+	Suggestion string
+}
+
+func (d Diagnostic) String() string {
+	pos := fmt.Sprintf("%s:%d", d.Path, d.Line)
+	if d.Column > 0 {
+		pos += fmt.Sprintf(":%d", d.Column)
+	}
+	s := fmt.Sprintf("%s: %s: %s", pos, d.Severity, d.Message)
+	if d.Suggestion != "" {
+		s += "\n    fix: " + d.Suggestion
+	}
+	return s
+}
+
+// HasErrors reports whether any of diags is an error.
+func HasErrors(diags []Diagnostic) bool {
+	for _, d := range diags {
+		if d.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateConfig checks the .ci-mgmt.yaml at path, with the defaults from the
+// given template set and anything it extends applied, for every problem which
+// would stop generation or produce broken workflows. Paths referenced by the
+// config are resolved relative to repoDir. The returned diagnostics are sorted
+// by file and position; an error is only returned if the config could not be
+// read at all.
+func ValidateConfig(templates fs.FS, path, repoDir string) ([]Diagnostic, error) {
+	return validateConfig(templates, path, repoDir, "", "")
+}
+
+// ValidateConfigData is ValidateConfig for a config which hasn't been written
+// to path yet, with data as its content.
+func ValidateConfigData(templates fs.FS, path string, data []byte, repoDir string) ([]Diagnostic, error) {
+	return validateConfigData(templates, path, data, repoDir, "", "")
+}
+
+// validateConfig is ValidateConfig with the config's template and repository
+// name replaced by templateName and repositoryName, if set.
+func validateConfig(templates fs.FS, path, repoDir, templateName, repositoryName string) ([]Diagnostic, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file %s: %w", path, err)
+	}
+	return validateConfigData(templates, path, data, repoDir, templateName, repositoryName)
+}
+
+func validateConfigData(templates fs.FS, path string, data []byte, repoDir, templateName, repositoryName string) ([]Diagnostic, error) {
+	v := validator{path: path, repoDir: repoDir}
+
+	layers, err := loadConfigLayersData(templates, path, data)
+	if err != nil {
+		return v.layerError(err)
+	}
+
+	for _, layer := range layers {
+		if !layer.isFile() {
+			continue
+		}
+		v.path = layer.name
+		v.checkFields(layer.root, reflect.TypeOf(Config{}), "")
+		v.checkTypes(layer.root)
+	}
+	v.path = path
+
+	merged, err := mergeConfigLayers(layers)
+	if err != nil {
+		if HasErrors(v.diags) {
+			// The checks above report anything which can't be merged.
+			return v.sorted(), nil
+		}
+		return v.layerError(err)
+	}
+	config, err := loadActionVersionDefaults()
+	if err != nil {
+		return nil, err
+	}
+	if v.pinned, err = pinnedActions(); err != nil {
+		return nil, err
+	}
+	// Type errors were reported for each layer above.
+	_ = merged.decode(&config)
+	v.layers = merged.layers
+	if templateName != "" {
+		config.Template = templateName
+		v.templateOverridden = true
+	}
+	if repositoryName == "" {
+		repositoryName = RepositoryName(config)
+	}
+	v.repositoryName = repositoryName
+
+	v.checkValues(merged.root, config)
+	for _, layer := range layers {
+		if layer.isFile() {
+			v.diags = append(v.diags, deprecationDiagnostics(layer.name, layer.root, config.Template)...)
+		}
+	}
+
+	return v.sorted(), nil
+}
+
+type validator struct {
+	// path is the file being checked.
+	path    string
+	repoDir string
+	// layers maps merged config nodes to the file they came from.
+	layers map[*yaml.Node]string
+	diags  []Diagnostic
+	// templateOverridden is true if the template being checked was given
+	// on the command line rather than in the config.
+	templateOverridden bool
+	// repositoryName is the repository generated into, which may be given
+	// on the command line rather than in the config.
+	repositoryName string
+	// pinned holds the uses of each action in action-versions.yml (see
+	// pinnedActions).
+	pinned map[string]string
+}
+
+// sorted returns the diagnostics sorted by file and position.
+func (v *validator) sorted() []Diagnostic {
+	sort.SliceStable(v.diags, func(i, j int) bool {
+		a, b := v.diags[i], v.diags[j]
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+	return v.diags
+}
+
+// layerError reports a problem loading or merging config layers, or returns
+// err if it is not one.
+func (v *validator) layerError(err error) ([]Diagnostic, error) {
+	var le *layerError
+	if !errors.As(err, &le) {
+		return nil, err
+	}
+	v.diags = append(v.diags, Diagnostic{Path: le.path, Line: le.line, Column: le.column, Severity: SeverityError, Message: le.msg})
+	return v.sorted(), nil
+}
+
+// checkTypes reports values in a layer which can't be decoded.
+func (v *validator) checkTypes(root *yaml.Node) {
+	var config Config
+	err := root.Decode(&config)
+	if err == nil {
+		return
+	}
+	var typeErr *yaml.TypeError
+	if !errors.As(err, &typeErr) {
+		// Custom unmarshalers don't report a position.
+		v.errorAt(root, err.Error(), "")
+		return
+	}
+	for _, e := range typeErr.Errors {
+		line, msg := splitYAMLError(e)
+		v.report(line, columnOfLine(root, line), SeverityError, msg, "")
+	}
+}
+
+func (v *validator) report(line, column int, severity Severity, message, suggestion string) {
+	v.reportIn(v.path, line, column, severity, message, suggestion)
+}
+
+func (v *validator) reportIn(path string, line, column int, severity Severity, message, suggestion string) {
+	v.diags = append(v.diags, Diagnostic{
+		Path:       path,
+		Line:       line,
+		Column:     column,
+		Severity:   severity,
+		Message:    message,
+		Suggestion: suggestion,
+	})
+}
+
+// pathOf returns the file which node came from.
+func (v *validator) pathOf(node *yaml.Node) string {
+	if p, ok := v.layers[node]; ok {
+		return p
+	}
+	return v.path
+}
+
+func (v *validator) errorAt(node *yaml.Node, message, suggestion string) {
+	v.reportIn(v.pathOf(node), node.Line, node.Column, SeverityError, message, suggestion)
+}
+
+func (v *validator) warningAt(node *yaml.Node, message, suggestion string) {
+	v.reportIn(v.pathOf(node), node.Line, node.Column, SeverityWarning, message, suggestion)
+}
+
+// checkFields reports keys in node which t does not declare.
+func (v *validator) checkFields(node *yaml.Node, t reflect.Type, prefix string) {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			// Reported as a type error when decoding.
+			return
+		}
+		fields := map[string]reflect.StructField{}
+		var names []string
+		for i := range t.NumField() {
+			if name, ok := yamlFieldName(t.Field(i)); ok {
+				fields[name] = t.Field(i)
+				names = append(names, name)
+			}
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			name, appending := strings.CutSuffix(key.Value, appendSuffix)
+			field, ok := fields[name]
+			if ok && appending && field.Type.Kind() != reflect.Slice {
+				v.errorAt(key, fmt.Sprintf("%q is not a list and can't be appended to", prefix+name), fmt.Sprintf("use %q to replace it", prefix+name))
+				continue
+			}
+			if !ok {
+				suggestion := ""
+				if closest := closestName(key.Value, names); closest != "" {
+					suggestion = fmt.Sprintf("did you mean %q?", prefix+closest)
+				}
+				v.errorAt(key, fmt.Sprintf("unknown field %q", prefix+key.Value), suggestion)
+				continue
+			}
+			v.checkFields(value, field.Type, prefix+name+".")
+		}
+	case reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			return
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			v.checkFields(node.Content[i+1], t.Elem(), prefix+node.Content[i].Value+".")
+		}
+	case reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			return
+		}
+		for i, item := range node.Content {
+			v.checkFields(item, t.Elem(), fmt.Sprintf("%s[%d].", strings.TrimSuffix(prefix, "."), i))
+		}
+	}
+}
+
+// checkValues reports values which decode but which generation cannot use.
+// config is the decoded config with defaults applied.
+func (v *validator) checkValues(root *yaml.Node, config Config) {
+	if config.Provider == "" {
+		v.errorAt(nodeOrRoot(root, "provider"), "provider is required", `set "provider" to the provider name without the "pulumi-" prefix, e.g. "aws"`)
+	}
+
+	if _, err := getTemplateDirs(config.Template); err != nil {
+		suggestion := "must be one of: " + strings.Join(templateNames, ", ")
+		if closest := closestName(config.Template, templateNames); closest != "" {
+			suggestion = fmt.Sprintf("did you mean %q?", closest)
+		}
+		node := nodeOrRoot(root, "template")
+		if v.templateOverridden {
+			node = root
+		}
+		v.errorAt(node, fmt.Sprintf("unknown template %q", config.Template), suggestion)
+	}
+
+	if languages := lookupNode(root, "languages"); languages != nil && languages.Kind == yaml.SequenceNode {
+		for _, language := range languages.Content {
+			if slices.Contains(supportedLanguages, language.Value) {
+				continue
+			}
+			suggestion := "must be one of: " + strings.Join(supportedLanguages, ", ")
+			if closest := closestName(language.Value, supportedLanguages); closest != "" {
+				suggestion = fmt.Sprintf("did you mean %q?", closest)
+			}
+			v.errorAt(language, fmt.Sprintf("unknown language %q", language.Value), suggestion)
+		}
+	}
+
+	if testOS := lookupNode(root, "runner", "testOs"); testOS != nil && testOS.Kind == yaml.SequenceNode {
+		for _, name := range testOS.Content {
+			switch {
+			case !slices.Contains(testOSes, name.Value):
+				suggestion := "must be one of: " + strings.Join(testOSes, ", ")
+				if closest := closestName(name.Value, testOSes); closest != "" {
+					suggestion = fmt.Sprintf("did you mean %q?", closest)
+				}
+				v.errorAt(name, fmt.Sprintf("unknown runner.testOs %q", name.Value), suggestion)
+			case name.Value != "ubuntu" && slices.Contains(linuxOnlyTemplates, config.Template):
+				v.errorAt(name, fmt.Sprintf("%s test runners aren't supported by the %s template, which only builds the linux-amd64 provider binary", name.Value, config.Template),
+					"only test on ubuntu")
+			}
+		}
+	}
+
+	for key, timeout := range map[string]intOrDuration{
+		"timeout":                   config.Timeout,
+		"jobTimeouts.prerequisites": config.JobTimeouts.Prerequisites,
+		"jobTimeouts.buildSdk":      config.JobTimeouts.BuildSDK,
+		"jobTimeouts.test":          config.JobTimeouts.Test,
+		"jobTimeouts.publish":       config.JobTimeouts.Publish,
+	} {
+		node := lookupNode(root, strings.Split(key, ".")...)
+		switch {
+		case node == nil:
+		case timeout < 0:
+			v.errorAt(node, fmt.Sprintf("%s must not be negative, got %s", key, node.Value),
+				"use a number of minutes or a duration such as 2h30m")
+		case time.Duration(timeout) > maxTimeout:
+			v.errorAt(node, fmt.Sprintf("%s is %s but GitHub cancels jobs after %d minutes", key, node.Value, int(maxTimeout/time.Minute)),
+				"use at most 360 minutes (6h)")
+		}
+	}
+
+	// 0 is unset and defaults to 1.
+	if day := lookupNode(root, "maintenanceReleaseDay"); day != nil && (config.MaintenanceReleaseDay < 0 || config.MaintenanceReleaseDay > 28) {
+		v.errorAt(day, fmt.Sprintf("maintenanceReleaseDay must be between 1 and 28, or 0 for the default, got %d", config.MaintenanceReleaseDay),
+			"use a day which occurs in every month")
+	}
+
+	// Third-party providers are skipped by generate, so this only matters for
+	// Pulumi's own. Without ESC, renderEscStep fails part way through rendering.
+	if !config.ESC.Enabled && strings.HasPrefix(v.repositoryName, "pulumi/") {
+		node := lookupNode(root, "esc", "enabled")
+		if node == nil {
+			node = nodeOrRoot(root, "esc")
+		}
+		v.errorAt(node, "ESC must be enabled for Pulumi providers", "set `esc: { enabled: true }`")
+	}
+
+	for _, key := range []string{"env", "envOverride"} {
+		v.checkEnv(root, "", config.ESC.Enabled, key)
+	}
+	for _, scope := range envScopes[1:] {
+		v.checkEnv(root, EnvScope(scope), config.ESC.Enabled, "jobEnv", scope)
+	}
+
+	for _, hook := range actionHooks {
+		v.checkSteps(root, "actions", hook)
+	}
+
+	// dorny/paths-filter matches globs against paths relative to the
+	// repository root, so "./provider/**" never matches anything.
+	for _, kind := range []string{"buildProvider", "buildSdk", "test"} {
+		for _, list := range []string{"include", "exclude"} {
+			globs := lookupNode(root, "pathFilters", kind, list)
+			if globs == nil || globs.Kind != yaml.SequenceNode {
+				continue
+			}
+			for _, glob := range globs.Content {
+				p := fmt.Sprintf("pathFilters.%s.%s", kind, list)
+				switch {
+				case strings.TrimSpace(glob.Value) == "":
+					v.errorAt(glob, fmt.Sprintf("%s contains an empty glob", p), "remove it")
+				case strings.HasPrefix(glob.Value, "./"), strings.HasPrefix(glob.Value, "/"):
+					v.warningAt(glob, fmt.Sprintf("%s glob %q never matches", p, glob.Value),
+						fmt.Sprintf("use a path relative to the repository root, e.g. %q", strings.TrimLeft(glob.Value, "./")))
+				}
+			}
+		}
+	}
+
+	// Each kind also runs whenever a later kind, which depends on it, runs,
+	// so filtering it has no effect unless every later kind is filtered too.
+	kinds, filters := config.PathFilters.kinds()
+	for i, filter := range filters {
+		if filter == nil {
+			continue
+		}
+		var unfiltered []string
+		for j := i + 1; j < len(kinds); j++ {
+			if filters[j] == nil {
+				unfiltered = append(unfiltered, kinds[j])
+			}
+		}
+		if len(unfiltered) == 0 {
+			continue
+		}
+		verbs := "runs and depends"
+		if len(unfiltered) > 1 {
+			verbs = "run and depend"
+		}
+		names := strings.Join(unfiltered, " and ")
+		v.warningAt(nodeOrRoot(lookupNode(root, "pathFilters"), kinds[i]),
+			fmt.Sprintf("pathFilters.%s has no effect because %s always %s on it", kinds[i], names, verbs),
+			fmt.Sprintf("filter %s too", names))
+	}
+
+	v.checkConcurrency(root, config.Concurrency)
+
+	if verification := lookupNode(root, "releaseVerification"); verification != nil && verification.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(verification.Content); i += 2 {
+			key, value := verification.Content[i], verification.Content[i+1]
+			if value.Value == "" {
+				continue
+			}
+			info, err := os.Stat(filepath.Join(v.repoDir, filepath.FromSlash(value.Value)))
+			switch {
+			case err != nil:
+				v.warningAt(value, fmt.Sprintf("releaseVerification.%s directory %q does not exist", key.Value, value.Value),
+					fmt.Sprintf("add the example or remove releaseVerification.%s", key.Value))
+			case !info.IsDir():
+				v.warningAt(value, fmt.Sprintf("releaseVerification.%s path %q is not a directory", key.Value, value.Value),
+					"point it at the example's directory")
+			}
+		}
+	}
+}
+
+// checkConcurrency reports concurrency which could cancel a release or a
+// publish from the main workflow. Main and release runs must not cancel the
+// run in progress, each release needs a group of its own so a queued release
+// isn't replaced by the next, and workflows which do cancel mustn't share a
+// group with them.
+func (v *validator) checkConcurrency(root *yaml.Node, c concurrencies) {
+	for kind, runs := range map[string]string{"main": "publishing", "release": "releases"} {
+		if k, _ := c.forKind(kind); k != nil && k.CancelInProgress {
+			v.errorAt(nodeOrRoot(lookupNode(root, "concurrency", kind), "cancelInProgress"),
+				fmt.Sprintf("concurrency.%s.cancelInProgress would cancel %s in progress", kind, runs), "set it to false")
+		}
+	}
+	// GitHub keeps only the newest pending run in a group, so a shared group
+	// drops queued runs even if they aren't cancelled in progress.
+	for kind, runs := range map[string]string{"main": "pushes to main", "release": "releases"} {
+		k, _ := c.forKind(kind)
+		if k == nil || strings.Contains(k.Group, "github.sha") || strings.Contains(k.Group, "github.run_id") {
+			continue
+		}
+		v.errorAt(nodeOrRoot(lookupNode(root, "concurrency", kind), "group"),
+			fmt.Sprintf("concurrency.%s.group %q is shared by successive %s, so a queued run would be dropped by the next", kind, k.Group, runs),
+			"include ${{ github.sha }} in the group")
+	}
+	for _, kind := range []string{"pullRequest", "upgrade"} {
+		k, _ := c.forKind(kind)
+		if k == nil || !k.CancelInProgress || strings.Contains(k.Group, "github.workflow") {
+			continue
+		}
+		for _, other := range []string{"main", "release"} {
+			if o, _ := c.forKind(other); o != nil && o.Group == k.Group {
+				v.errorAt(nodeOrRoot(lookupNode(root, "concurrency", kind), "group"),
+					fmt.Sprintf("concurrency.%s.group is also the %s group, so %s runs would cancel %s runs", kind, other, kind, other),
+					"include ${{ github.workflow }} in the group")
+			}
+		}
+	}
+}
+
+// checkEnv reports entries in the env mapping at keys which can't be rendered
+// as declared, and secrets which would be given to every job. scope is the
+// kind of job the mapping is for, if it is in jobEnv.
+func (v *validator) checkEnv(root *yaml.Node, scope EnvScope, escEnabled bool, keys ...string) {
+	env := lookupNode(root, keys...)
+	if env == nil || env.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 0; i+1 < len(env.Content); i += 2 {
+		name, node := env.Content[i], env.Content[i+1]
+		var entry EnvVar
+		if err := node.Decode(&entry); err != nil {
+			continue // Reported as a type error.
+		}
+		p := strings.Join(keys, ".") + "." + name.Value
+
+		effective := scope
+		if entry.untyped {
+			if strings.Contains(entry.Value, "secrets.") && !strings.Contains(entry.Value, "${{") {
+				v.warningAt(node, fmt.Sprintf("%s is treated as a secret because it mentions \"secrets.\"", p),
+					"declare it as `{ value: ... }` if it is plaintext")
+			}
+		} else {
+			var kinds []string
+			for kind, value := range map[string]string{"value": entry.Value, "secret": entry.Secret, "esc": entry.ESC} {
+				if value != "" {
+					kinds = append(kinds, kind)
+				}
+			}
+			if len(kinds) != 1 {
+				v.errorAt(node, fmt.Sprintf("%s must set exactly one of value, secret or esc", p), "")
+				continue
+			}
+			switch {
+			case !slices.Contains(envScopes, string(entry.scope())):
+				suggestion := "must be one of: " + strings.Join(envScopes, ", ")
+				if closest := closestName(string(entry.Scope), envScopes); closest != "" {
+					suggestion = fmt.Sprintf("did you mean %q?", closest)
+				}
+				v.errorAt(nodeOrRoot(node, "scope"), fmt.Sprintf("unknown %s.scope %q", p, entry.Scope), suggestion)
+				continue
+			case scope != "" && entry.Scope != "" && entry.Scope != scope:
+				v.errorAt(nodeOrRoot(node, "scope"), fmt.Sprintf("%s has scope %q but is in %s", p, entry.Scope, strings.Join(keys, ".")),
+					fmt.Sprintf("remove its scope or move it to jobEnv.%s", entry.Scope))
+				continue
+			case scope == "":
+				effective = entry.scope()
+			}
+		}
+		if effective == "" {
+			effective = EnvScopeAll
+		}
+
+		if entry.ESC != "" {
+			if !escEnabled {
+				v.errorAt(nodeOrRoot(node, "esc"), fmt.Sprintf("%s reads %s from ESC but ESC is disabled", p, entry.ESC),
+					"set `esc: { enabled: true }` or use `secret:` for a GitHub secret")
+			}
+			if effective != EnvScopeAll && effective != EnvScopeTest {
+				v.errorAt(node, fmt.Sprintf("%s reads %s from ESC, which is only available to test steps", p, entry.ESC),
+					"use `secret:` for a GitHub secret needed by other jobs")
+			}
+			continue
+		}
+		// Without ESC unscoped secrets are in the env of every workflow. With
+		// ESC they are only given to test steps, as jobEnv.test would be.
+		if entry.isSecret() && effective == EnvScopeAll && !escEnabled {
+			v.warningAt(node, fmt.Sprintf("%s is a secret given to every job", p),
+				"move it to jobEnv, e.g. jobEnv.test, so only the jobs which need it can read it")
+		}
+	}
+}
+
+// checkSteps reports custom steps at keys which GitHub would reject, and
+// actions which aren't pinned like those in action-versions.yml.
+func (v *validator) checkSteps(root *yaml.Node, keys ...string) {
+	steps := lookupNode(root, keys...)
+	if steps == nil || steps.Kind != yaml.SequenceNode {
+		return
+	}
+	for i, node := range steps.Content {
+		var step Step
+		if err := node.Decode(&step); err != nil {
+			continue // Reported as a type error.
+		}
+		p := fmt.Sprintf("%s[%d]", strings.Join(keys, "."), i)
+		if (step.Uses == "") == (step.Run == "") {
+			v.errorAt(node, fmt.Sprintf("%s must set exactly one of uses or run", p), "")
+			continue
+		}
+		if step.Uses == "" {
+			if len(step.With) > 0 {
+				v.errorAt(nodeOrRoot(node, "with"), fmt.Sprintf("%s sets with but doesn't use an action", p), "pass values to run steps with env")
+			}
+			continue
+		}
+		v.checkUses(nodeOrRoot(node, "uses"), p, step.Uses)
+	}
+}
+
+// checkUses reports a step's uses if it isn't an action reference, or names
+// an action at a different version from action-versions.yml or at a tag or
+// branch rather than a commit.
+func (v *validator) checkUses(node *yaml.Node, p, uses string) {
+	if strings.HasPrefix(uses, "./") || strings.HasPrefix(uses, "docker://") {
+		return
+	}
+	action, ref, ok := strings.Cut(uses, "@")
+	if !ok || ref == "" || !strings.Contains(action, "/") {
+		v.errorAt(node, fmt.Sprintf("%s uses %q, which isn't an action", p, uses),
+			"use owner/repo@ref, ./path/to/action or docker://image")
+		return
+	}
+	if pinned, ok := v.pinned[action]; ok {
+		if pinnedRef, _, _ := strings.Cut(strings.TrimPrefix(pinned, action+"@"), " "); ref != pinnedRef {
+			v.warningAt(node, fmt.Sprintf("%s uses %s but the generated workflows use %s", p, uses, pinned),
+				fmt.Sprintf("use `uses: %s`", pinned))
+		}
+		return
+	}
+	if len(ref) != 40 || strings.Trim(ref, "0123456789abcdef") != "" {
+		v.warningAt(node, fmt.Sprintf("%s uses %s, which isn't pinned to a commit", p, uses),
+			fmt.Sprintf("use `uses: %s@<commit> # %s` so the step can't change underneath you", action, ref))
+	}
+}
+
+// deprecationDiagnostics warns about each field in root which is deprecated
+// for the given template.
+func deprecationDiagnostics(path string, root *yaml.Node, templateName string) []Diagnostic {
+	var diags []Diagnostic
+	for _, field := range deprecations.For(templateName) {
+		for i := 0; i+1 < len(root.Content); i += 2 {
+			if key := root.Content[i]; key.Value == field.Key {
+				diags = append(diags, Diagnostic{
+					Path:       path,
+					Line:       key.Line,
+					Column:     key.Column,
+					Severity:   SeverityWarning,
+					Message:    field.Message(),
+					Suggestion: field.Suggestion(),
+				})
+			}
+		}
+	}
+	return diags
+}
+
+// lookupNode returns the value at the given keys in a mapping node, or nil.
+func lookupNode(node *yaml.Node, keys ...string) *yaml.Node {
+	for _, key := range keys {
+		if node.Kind != yaml.MappingNode {
+			return nil
+		}
+		var next *yaml.Node
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == key {
+				next = node.Content[i+1]
+			}
+		}
+		if next == nil {
+			return nil
+		}
+		node = next
+	}
+	return node
+}
+
+// nodeOrRoot returns the value at key, or root if it is unset so problems with
+// defaults are reported at the top of the file.
+func nodeOrRoot(root *yaml.Node, key string) *yaml.Node {
+	if node := lookupNode(root, key); node != nil {
+		return node
+	}
+	return root
+}
+
+// splitYAMLError splits a yaml error such as "yaml: line 3: did not find
+// expected key" into its line and message. The line is 1 if there is none.
+func splitYAMLError(msg string) (int, string) {
+	msg = strings.TrimPrefix(msg, "yaml: ")
+	var line int
+	if _, err := fmt.Sscanf(msg, "line %d:", &line); err != nil {
+		return 1, msg
+	}
+	_, msg, _ = strings.Cut(msg, ": ")
+	return line, msg
+}
+
+// columnOfLine returns the column of the last node on the given line, which
+// for "key: value" is the value, or 0 if there is none.
+func columnOfLine(node *yaml.Node, line int) int {
+	column := 0
+	if node.Line == line {
+		column = node.Column
+	}
+	for _, child := range node.Content {
+		if c := columnOfLine(child, line); c > column {
+			column = c
+		}
+	}
+	return column
+}
+
+// closestName returns the candidate nearest to name by edit distance, or ""
+// if none is close enough to be a likely typo.
+func closestName(name string, candidates []string) string {
+	best, bestDistance := "", len(name)/3+2
+	for _, candidate := range candidates {
+		if d := editDistance(strings.ToLower(name), strings.ToLower(candidate)); d < bestDistance {
+			best, bestDistance = candidate, d
+		}
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
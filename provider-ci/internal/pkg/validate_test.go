@@ -0,0 +1,488 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateConfigReportsEveryProblem(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "examples", "nodejs"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	configPath := filepath.Join(dir, ".ci-mgmt.yaml")
+	config := `provider: foo
+template: bridged-providr
+languages:
+  - nodejs
+  - pyhton
+maintenanceReleaseDay: 31
+lnit: true
+esc:
+  enabled: false
+shards: abc
+releaseVerification:
+  nodejs: examples/nodejs
+  go: examples/go
+`
+	if err := os.WriteFile(configPath, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	diags, err := ValidateConfig(embeddedTemplates, configPath, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type problem struct {
+		line, column int
+		severity     Severity
+		suggestion   string
+	}
+	expected := []problem{
+		{2, 11, SeverityError, `did you mean "bridged-provider"?`},
+		{5, 5, SeverityError, `did you mean "python"?`},
+		{6, 24, SeverityError, "use a day which occurs in every month"},
+		{7, 1, SeverityError, `did you mean "lint"?`},
+		{9, 12, SeverityError, "set `esc: { enabled: true }`"},
+		{10, 9, SeverityError, ""},
+		{13, 7, SeverityWarning, "add the example or remove releaseVerification.go"},
+	}
+	if len(diags) != len(expected) {
+		t.Fatalf("expected %d diagnostics, got %d: %v", len(expected), len(diags), diags)
+	}
+	for i, e := range expected {
+		d := diags[i]
+		if d.Path != configPath || d.Line != e.line || d.Column != e.column || d.Severity != e.severity || d.Suggestion != e.suggestion {
+			t.Fatalf("diagnostic %d: expected %+v, got %+v", i, e, d)
+		}
+	}
+	if !HasErrors(diags) {
+		t.Fatal("expected errors")
+	}
+}
+
+func TestValidateConfigReportsSyntaxErrors(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, ".ci-mgmt.yaml")
+	if err := os.WriteFile(configPath, []byte("provider: foo\nenv: [\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	diags, err := ValidateConfig(embeddedTemplates, configPath, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diags) != 1 || diags[0].Severity != SeverityError || diags[0].Line != 2 {
+		t.Fatalf("expected one syntax error on line 2, got %v", diags)
+	}
+}
+
+func TestValidateConfigAcceptsTestProviderConfigs(t *testing.T) {
+	paths, err := filepath.Glob("../../test-providers/*/.ci-mgmt.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range paths {
+		diags, err := ValidateConfig(embeddedTemplates, path, filepath.Dir(path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if HasErrors(diags) {
+			t.Fatalf("expected %s to be valid, got %v", path, diags)
+		}
+	}
+}
+
+func TestValidateConfigReportsEnvProblems(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		".ci-mgmt.yaml": `provider: foo
+esc:
+  enabled: false
+env:
+  PLAIN: see secrets.md
+  BOTH:
+    value: x
+    secret: X
+  SCOPED:
+    value: x
+    scope: tests
+  FROM_ESC:
+    esc: FROM_ESC
+    scope: publish
+  EXPOSED:
+    secret: EXPOSED
+jobEnv:
+  test:
+    MISPLACED:
+      value: x
+      scope: publish
+  buildSdk:
+    TOKEN:
+      secret: TOKEN
+`,
+	})
+	configPath := filepath.Join(dir, ".ci-mgmt.yaml")
+
+	diags, err := ValidateConfig(embeddedTemplates, configPath, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		`env.PLAIN is treated as a secret because it mentions "secrets."`,
+		"env.PLAIN is a secret given to every job",
+		"env.BOTH must set exactly one of value, secret or esc",
+		`unknown env.SCOPED.scope "tests"`,
+		"env.FROM_ESC reads FROM_ESC from ESC, which is only available to test steps",
+		"env.FROM_ESC reads FROM_ESC from ESC but ESC is disabled",
+		"env.EXPOSED is a secret given to every job",
+		`jobEnv.test.MISPLACED has scope "publish" but is in jobEnv.test`,
+	}
+	var messages []string
+	for _, d := range diags {
+		if strings.Contains(d.Message, "env.") || strings.Contains(d.Message, "Env.") {
+			messages = append(messages, d.Message)
+		}
+	}
+	if !reflect.DeepEqual(messages, expected) {
+		t.Fatalf("expected %q, got %q", expected, messages)
+	}
+}
+
+func TestValidateConfigReportsUnscopedSecrets(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		".ci-mgmt.yaml": `provider: foo
+organization: example
+env:
+  EXPOSED:
+    secret: EXPOSED
+  SCOPED:
+    secret: SCOPED
+    scope: test
+jobEnv:
+  publish:
+    TOKEN:
+      secret: TOKEN
+`,
+	})
+
+	diags, err := ValidateConfig(embeddedTemplates, filepath.Join(dir, ".ci-mgmt.yaml"), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var messages []string
+	for _, d := range diags {
+		messages = append(messages, d.Message)
+	}
+	expected := []string{"env.EXPOSED is a secret given to every job"}
+	if !reflect.DeepEqual(messages, expected) {
+		t.Fatalf("expected %q, got %q", expected, messages)
+	}
+}
+
+func TestValidateConfigAllowsUnscopedSecretsWithESC(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		".ci-mgmt.yaml": `provider: foo
+esc:
+  enabled: true
+env:
+  EXPOSED:
+    secret: EXPOSED
+`,
+	})
+
+	diags, err := ValidateConfig(embeddedTemplates, filepath.Join(dir, ".ci-mgmt.yaml"), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// With ESC the secret is only given to test steps.
+	if len(diags) != 0 {
+		t.Fatalf("expected no diagnostics, got %v", diags)
+	}
+}
+
+func TestValidateConfigReportsRunnerProblems(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		".ci-mgmt.yaml": `provider: foo
+esc:
+  enabled: true
+runner:
+  testOs: [ubuntu, macos, windoes]
+`,
+	})
+
+	diags, err := ValidateConfig(embeddedTemplates, filepath.Join(dir, ".ci-mgmt.yaml"), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diags) != 1 {
+		t.Fatalf("expected 1 diagnostic, got %v", diags)
+	}
+	if diags[0].Severity != SeverityError || diags[0].Column != 27 || diags[0].Suggestion != `did you mean "windows"?` {
+		t.Fatalf("expected an unknown OS error for windoes, got %v", diags[0])
+	}
+}
+
+func TestValidateConfigRejectsTestOSForLinuxOnlyTemplates(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		".ci-mgmt.yaml": `provider: foo
+template: native
+esc:
+  enabled: true
+runner:
+  testOs: [ubuntu, macos]
+`,
+	})
+
+	diags, err := ValidateConfig(embeddedTemplates, filepath.Join(dir, ".ci-mgmt.yaml"), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found []Diagnostic
+	for _, d := range diags {
+		if strings.Contains(d.Message, "test runners") {
+			found = append(found, d)
+		}
+	}
+	// The native templates only build the linux provider binary.
+	if len(found) != 1 || found[0].Severity != SeverityError || found[0].Column != 20 ||
+		!strings.Contains(found[0].Message, "macos test runners aren't supported by the native template") {
+		t.Fatalf("expected an unsupported OS error for macos, got %v", diags)
+	}
+}
+
+func TestValidateConfigRejectsSharedMainConcurrencyGroup(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		".ci-mgmt.yaml": `provider: foo
+esc:
+  enabled: true
+concurrency:
+  main:
+    group: ${{ github.workflow }}-${{ github.ref }}
+  release: {}
+`,
+	})
+
+	diags, err := ValidateConfig(embeddedTemplates, filepath.Join(dir, ".ci-mgmt.yaml"), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Without cancelInProgress a newer push still replaces a queued run, and
+	// the default release group is per commit.
+	if len(diags) != 1 || diags[0].Severity != SeverityError || diags[0].Line != 6 ||
+		!strings.Contains(diags[0].Message, "concurrency.main.group") || diags[0].Suggestion != "include ${{ github.sha }} in the group" {
+		t.Fatalf("expected an error for the shared main group, got %v", diags)
+	}
+}
+
+func TestValidateConfigAcceptsDefaultMaintenanceReleaseDay(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		".ci-mgmt.yaml": `provider: foo
+esc:
+  enabled: true
+maintenanceReleaseDay: 0
+`,
+	})
+
+	diags, err := ValidateConfig(embeddedTemplates, filepath.Join(dir, ".ci-mgmt.yaml"), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diags) != 0 {
+		t.Fatalf("expected 0 to mean the default day, got %v", diags)
+	}
+}
+
+func TestValidateConfigReportsTimeoutProblems(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		".ci-mgmt.yaml": `provider: foo
+esc:
+  enabled: true
+timeout: 150
+jobTimeouts:
+  test: 8h
+  publish: -5m
+`,
+	})
+
+	diags, err := ValidateConfig(embeddedTemplates, filepath.Join(dir, ".ci-mgmt.yaml"), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var messages []string
+	for _, d := range diags {
+		messages = append(messages, d.Message)
+	}
+	expected := []string{
+		"jobTimeouts.test is 8h but GitHub cancels jobs after 360 minutes",
+		"jobTimeouts.publish must not be negative, got -5m",
+	}
+	if !reflect.DeepEqual(messages, expected) {
+		t.Fatalf("expected %q, got %q", expected, messages)
+	}
+}
+
+func TestValidateConfigReportsStepProblems(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		".ci-mgmt.yaml": `provider: foo
+esc:
+  enabled: true
+actions:
+  preTest:
+    - name: Both
+      uses: ./.github/actions/setup
+      run: make setup
+    - name: With on run
+      run: make setup
+      with:
+        debug: true
+  postTest:
+    - uses: actions/checkout@v4
+    - uses: opentofu/setup-opentofu@v1
+    - uses: opentofu/setup-opentofu@12f4debbf681675350b6cd1f0ff8ecfbda62027b
+    - uses: setup-opentofu
+    - uses: docker://alpine:3
+      continue-on-error: true
+      timeout-minutes: 5
+`,
+	})
+
+	diags, err := ValidateConfig(embeddedTemplates, filepath.Join(dir, ".ci-mgmt.yaml"), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pinned, err := pinnedActions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var messages []string
+	for _, d := range diags {
+		messages = append(messages, string(d.Severity)+": "+d.Message)
+	}
+	expected := []string{
+		"error: actions.preTest[0] must set exactly one of uses or run",
+		"error: actions.preTest[1] sets with but doesn't use an action",
+		"warning: actions.postTest[0] uses actions/checkout@v4 but the generated workflows use " + pinned["actions/checkout"],
+		"warning: actions.postTest[1] uses opentofu/setup-opentofu@v1, which isn't pinned to a commit",
+		`error: actions.postTest[3] uses "setup-opentofu", which isn't an action`,
+	}
+	if !reflect.DeepEqual(messages, expected) {
+		t.Fatalf("expected %q, got %q", expected, messages)
+	}
+}
+
+func TestValidateConfigReportsPathFilterProblems(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		".ci-mgmt.yaml": `provider: foo
+esc:
+  enabled: true
+pathFilters:
+  buildSdk:
+    include: ["provider/**", ""]
+  test:
+    exclude: ["./docs/**"]
+`,
+	})
+
+	diags, err := ValidateConfig(embeddedTemplates, filepath.Join(dir, ".ci-mgmt.yaml"), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var messages []string
+	for _, d := range diags {
+		messages = append(messages, d.Message)
+	}
+	expected := []string{
+		"pathFilters.buildSdk.include contains an empty glob",
+		`pathFilters.test.exclude glob "./docs/**" never matches`,
+	}
+	if !reflect.DeepEqual(messages, expected) {
+		t.Fatalf("expected %q, got %q", expected, messages)
+	}
+}
+
+func TestValidateConfigReportsIneffectivePathFilters(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		".ci-mgmt.yaml": `provider: foo
+esc:
+  enabled: true
+pathFilters:
+  buildProvider:
+    include: ["provider/**"]
+  buildSdk:
+    include: ["provider/**", "sdk/**"]
+`,
+	})
+
+	diags, err := ValidateConfig(embeddedTemplates, filepath.Join(dir, ".ci-mgmt.yaml"), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var messages []string
+	for _, d := range diags {
+		messages = append(messages, d.Message)
+	}
+	expected := []string{
+		"pathFilters.buildProvider has no effect because test always runs and depends on it",
+		"pathFilters.buildSdk has no effect because test always runs and depends on it",
+	}
+	if !reflect.DeepEqual(messages, expected) {
+		t.Fatalf("expected %q, got %q", expected, messages)
+	}
+}
+
+func TestValidateConfigReportsConcurrencyProblems(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		".ci-mgmt.yaml": `provider: foo
+esc:
+  enabled: true
+concurrency:
+  pullRequest:
+    group: ci
+    cancelInProgress: true
+  main:
+    group: ci
+    cancelInProgress: true
+  release:
+    group: releases
+`,
+	})
+
+	diags, err := ValidateConfig(embeddedTemplates, filepath.Join(dir, ".ci-mgmt.yaml"), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var messages []string
+	for _, d := range diags {
+		messages = append(messages, d.Message)
+	}
+	expected := []string{
+		"concurrency.pullRequest.group is also the main group, so pullRequest runs would cancel main runs",
+		`concurrency.main.group "ci" is shared by successive pushes to main, so a queued run would be dropped by the next`,
+		"concurrency.main.cancelInProgress would cancel publishing in progress",
+		`concurrency.release.group "releases" is shared by successive releases, so a queued run would be dropped by the next`,
+	}
+	if !reflect.DeepEqual(messages, expected) {
+		t.Fatalf("expected %q, got %q", expected, messages)
+	}
+}
+
+func TestValidateConfigDataDoesNotNeedTheFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, ".ci-mgmt.yaml")
+
+	diags, err := ValidateConfigData(embeddedTemplates, path, []byte("provider: foo\nlanguages: [cobol]\n"), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !HasErrors(diags) || diags[0].Path != path {
+		t.Fatalf("expected errors in %s, got %v", path, diags)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected validation not to write the config, got err %v", err)
+	}
+}
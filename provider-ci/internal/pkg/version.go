@@ -0,0 +1,58 @@
+package pkg
+
+import (
+	"runtime/debug"
+)
+
+// Version overrides the ci-mgmt version recorded in .ci-mgmt.manifest.json. It
+// is set at build time, e.g.:
+//
+//	go build -ldflags "-X github.com/pulumi/ci-mgmt/provider-ci/internal/pkg.Version=$(git rev-parse HEAD)"
+//
+// When unset, the version is read from the build info: the module version when
+// installed with `go run github.com/pulumi/ci-mgmt/provider-ci@<ref>`, or the
+// VCS revision when built from a checkout.
+var Version string
+
+// BuildInfo describes the build of the running provider-ci binary.
+type BuildInfo struct {
+	// Version is the ci-mgmt version recorded in .ci-mgmt.manifest.json.
+	Version   string `yaml:"version"`
+	Revision  string `yaml:"revision,omitempty"`
+	Time      string `yaml:"time,omitempty"`
+	Modified  bool   `yaml:"modified,omitempty"`
+	GoVersion string `yaml:"goVersion"`
+}
+
+// ReadBuildInfo returns the build information stamped into the binary.
+func ReadBuildInfo() BuildInfo {
+	info := BuildInfo{Version: Version}
+	bi, ok := debug.ReadBuildInfo()
+	if ok {
+		info.GoVersion = bi.GoVersion
+		for _, setting := range bi.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				info.Revision = setting.Value
+			case "vcs.time":
+				info.Time = setting.Value
+			case "vcs.modified":
+				info.Modified = setting.Value == "true"
+			}
+		}
+	}
+
+	switch {
+	case info.Version != "":
+	case ok && bi.Main.Version != "" && bi.Main.Version != "(devel)":
+		info.Version = bi.Main.Version
+	case len(info.Revision) >= 12:
+		info.Version = info.Revision[:12]
+		if info.Modified {
+			info.Version += "-dirty"
+		}
+	default:
+		info.Version = "devel"
+	}
+	return info
+}
@@ -1,5 +1,6 @@
 #!/usr/bin/env python3
 # WARNING: This file is autogenerated - changes will be overwritten when regenerated by https://github.com/pulumi/ci-mgmt
+# Generated by ci-mgmt from template internal/.openinspect/setup.d/05-mise-global-fallback.py
 """Bake the mise global fallback into the image, once tools are installed.
 
 Sorts before the other setup.d hooks so anything they shell out to already
@@ -1,5 +1,6 @@
 #!/usr/bin/env python3
 # WARNING: This file is autogenerated - changes will be overwritten when regenerated by https://github.com/pulumi/ci-mgmt
+# Generated by ci-mgmt from template internal/.openinspect/setup.py
 """
 Runs once on fresh OpenInspect sandbox boot.
 
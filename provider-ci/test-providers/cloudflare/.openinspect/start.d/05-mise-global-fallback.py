@@ -1,5 +1,6 @@
 #!/usr/bin/env python3
 # WARNING: This file is autogenerated - changes will be overwritten when regenerated by https://github.com/pulumi/ci-mgmt
+# Generated by ci-mgmt from template internal/.openinspect/start.d/05-mise-global-fallback.py
 """Point the mise global fallback at the checkout this session is on.
 
 The image bakes a drop-in for whatever the default branch pinned; a session on
@@ -1,5 +1,6 @@
 #!/usr/bin/env python3
 # WARNING: This file is autogenerated - changes will be overwritten when regenerated by https://github.com/pulumi/ci-mgmt
+# Generated by ci-mgmt from template internal/.openinspect/mise_global_fallback.py
 """Mirror the repo's mise toolchain into a global drop-in, so it resolves anywhere.
 
 mise shims resolve a version from the *caller's* working directory. Anything that
@@ -1,5 +1,6 @@
 #!/usr/bin/env bash
 # WARNING: This file is autogenerated - changes will be overwritten when regenerated by https://github.com/pulumi/ci-mgmt
+# Generated by ci-mgmt from template base/scripts/upstream.sh
 
 set -e
 
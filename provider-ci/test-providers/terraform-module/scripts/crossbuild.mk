@@ -1,3 +1,4 @@
+# Generated by ci-mgmt from template base/scripts/crossbuild.mk
 # Provider cross-platform build & packaging
 
 SHELL := /bin/bash -o pipefail
@@ -1,5 +1,6 @@
 #!/usr/bin/env python3
 # WARNING: This file is autogenerated - changes will be overwritten when regenerated by https://github.com/pulumi/ci-mgmt
+# Generated by ci-mgmt from template internal-bridged/.openinspect/setup.d/10-prepare-local-workspace.py
 
 import os
 import subprocess
@@ -1,5 +1,6 @@
 #!/usr/bin/env python3
 # WARNING: This file is autogenerated - changes will be overwritten when regenerated by https://github.com/pulumi/ci-mgmt
+# Generated by ci-mgmt from template internal/.openinspect/start.py
 """Runs on every sandbox start, for whatever this session needs to be true now.
 
 Sibling of setup.py, which bakes the image; this reconciles the running